@@ -0,0 +1,125 @@
+package unimud
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Game text is UTF-8 internally. A connection transcodes it to and
+// from the client's character set, which is negotiated with telnet
+// CHARSET (RFC 2066) or chosen by the player.
+
+// A charset is a character set supported by the transcoder.
+type charset int
+
+const (
+	charsetUTF8 charset = iota
+	charsetLatin1
+	charsetASCII
+)
+
+// charsetNames maps character set names, as used by CHARSET and the
+// "charset" property, to character sets. Lookups are case
+// insensitive.
+var charsetNames = map[string]charset{
+	"utf-8":      charsetUTF8,
+	"utf8":       charsetUTF8,
+	"iso-8859-1": charsetLatin1,
+	"latin1":     charsetLatin1,
+	"us-ascii":   charsetASCII,
+	"ascii":      charsetASCII,
+}
+
+// The character sets offered to telnet clients, in order of
+// preference.
+var charsetOffer = []string{"UTF-8", "ISO-8859-1", "US-ASCII"}
+
+// charsetAuto is the "charset" property value that selects the
+// character set negotiated with the client.
+const charsetAuto = "auto"
+
+// lookupCharset returns the character set called `name`.
+func lookupCharset(name string) (charset, bool) {
+	cs, ok := charsetNames[strings.ToLower(strings.TrimSpace(name))]
+	return cs, ok
+}
+
+func (cs charset) String() string {
+	switch cs {
+	case charsetUTF8:
+		return "UTF-8"
+	case charsetLatin1:
+		return "ISO-8859-1"
+	case charsetASCII:
+		return "US-ASCII"
+	}
+	return "unknown"
+}
+
+// encode converts the UTF-8 text `s` to the character set.
+// Characters the set can't represent are replaced with '?'.
+func (cs charset) encode(s string) string {
+	limit := rune(0xff)
+	switch cs {
+	case charsetUTF8:
+		return s
+	case charsetASCII:
+		limit = 0x7f
+	}
+
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > limit {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// decode converts the text `s` from the character set to UTF-8.
+// Invalid UTF-8 sequences are dropped.
+func (cs charset) decode(s string) string {
+	switch cs {
+	case charsetUTF8:
+		if utf8.ValidString(s) {
+			return s
+		}
+		var b strings.Builder
+		for _, r := range s {
+			if r != utf8.RuneError {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
+
+// sanitizeInput cleans up a line of player input. Backspace and
+// delete characters erase the character before them, tabs become
+// spaces, and other control characters are removed.
+func sanitizeInput(s string) string {
+	var out []rune
+	for _, r := range s {
+		switch {
+		case r == '\b' || r == 0x7f:
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case r == '\t':
+			out = append(out, ' ')
+		case unicode.IsControl(r), r == utf8.RuneError:
+			// Drop it.
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
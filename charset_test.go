@@ -0,0 +1,139 @@
+package unimud
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCharsetEncode(t *testing.T) {
+	tests := []struct {
+		cs   charset
+		s    string
+		want string
+	}{
+		{charsetUTF8, "café 日本", "café 日本"},
+		{charsetLatin1, "café 日本", "caf\xe9 ??"},
+		{charsetLatin1, "plain", "plain"},
+		{charsetASCII, "café 日本", "caf? ??"},
+	}
+	for _, tt := range tests {
+		if got := tt.cs.encode(tt.s); got != tt.want {
+			t.Errorf("%v encode(%q) = %q, want %q", tt.cs, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCharsetDecode(t *testing.T) {
+	tests := []struct {
+		cs   charset
+		s    string
+		want string
+	}{
+		{charsetUTF8, "café", "café"},
+		{charsetUTF8, "caf\xe9!", "caf!"},
+		{charsetLatin1, "caf\xe9", "café"},
+		{charsetLatin1, "\xff", "ÿ"},
+		{charsetASCII, "plain", "plain"},
+	}
+	for _, tt := range tests {
+		if got := tt.cs.decode(tt.s); got != tt.want {
+			t.Errorf("%v decode(%q) = %q, want %q", tt.cs, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestLookupCharset(t *testing.T) {
+	tests := []struct {
+		name string
+		cs   charset
+		ok   bool
+	}{
+		{"UTF-8", charsetUTF8, true},
+		{" iso-8859-1 ", charsetLatin1, true},
+		{"Latin1", charsetLatin1, true},
+		{"US-ASCII", charsetASCII, true},
+		{"KOI8-R", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		if cs, ok := lookupCharset(tt.name); cs != tt.cs || ok != tt.ok {
+			t.Errorf("lookupCharset(%q) = %v, %v, want %v, %v", tt.name, cs, ok, tt.cs, tt.ok)
+		}
+	}
+}
+
+func TestSanitizeInput(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"look", "look"},
+		{"lookk\b", "look"},
+		{"lookk\x7f", "look"},
+		{"\b\blook", "look"},
+		{"say\thi", "say hi"},
+		{"say \x1b[31mhi", "say [31mhi"},
+		{"say hi\x00\x07", "say hi"},
+		{"say café\b", "say caf"},
+		{"say �", "say "},
+		{"say 日本", "say 日本"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeInput(tt.s); got != tt.want {
+			t.Errorf("sanitizeInput(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestReceiveCharset(t *testing.T) {
+	sub := func(cmd byte, data string) []byte {
+		b := []byte{telnetIAC, telnetSB, optCharset, cmd}
+		return append(append(b, data...), telnetIAC, telnetSE)
+	}
+	tests := []struct {
+		name  string
+		cmd   byte
+		data  string
+		cs    charset
+		reply []byte
+	}{
+		{"request", charsetRequest, ";KOI8-R;iso-8859-1;UTF-8", charsetLatin1,
+			sub(charsetAccepted, "iso-8859-1")},
+		{"other separator", charsetRequest, " US-ASCII", charsetASCII,
+			sub(charsetAccepted, "US-ASCII")},
+		{"translation tables", charsetRequest, "[TTABLE]\x01;latin1", charsetLatin1,
+			sub(charsetAccepted, "latin1")},
+		{"unsupported", charsetRequest, ";KOI8-R", charsetUTF8,
+			sub(charsetRejected, "")},
+		{"empty", charsetRequest, "", charsetUTF8,
+			sub(charsetRejected, "")},
+		{"accepted offer", charsetAccepted, "ISO-8859-1", charsetLatin1, nil},
+		{"accepted unknown", charsetAccepted, "KOI8-R", charsetUTF8, nil},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		c := &conn{telnet: newTelnet(nil, &out)}
+		c.receiveCharset(tt.cmd, tt.data)
+		if c.clientCharset() != tt.cs {
+			t.Errorf("%s: charset %v, want %v", tt.name, c.clientCharset(), tt.cs)
+		}
+		if !bytes.Equal(out.Bytes(), tt.reply) {
+			t.Errorf("%s: replied %q, want %q", tt.name, out.Bytes(), tt.reply)
+		}
+	}
+}
+
+func TestCharsetTranscoding(t *testing.T) {
+	_, addr := newTestGame(t, nil)
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+	a.send("set charset iso-8859-1")
+	a.expect("charset set to iso-8859-1.")
+
+	a.send("say caf\xe9\x07")
+	a.expect("You say, 'caf\xe9'.")
+	b.expect("alice says, 'café'.")
+
+	b.send("say 日本")
+	a.expect("bobby says, '??'.")
+}
@@ -0,0 +1,227 @@
+package unimud
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Game text may contain inline color markup, which is rendered
+// according to the capabilities of each player's client:
+//
+//	{k} {r} {g} {y} {b} {m} {c} {w}   black, red, green, yellow, blue,
+//	                                  magenta, cyan and white
+//	{K} {R} {G} {Y} {B} {M} {C} {W}   bright versions of the above
+//	{#rrggbb}                         any color, given in hex
+//	{x}                               reset to the default color
+//	{{                                a literal '{'
+//
+// Anything else enclosed in braces is output unchanged.
+
+// A colorMode describes how color markup is rendered on a
+// connection.
+type colorMode int
+
+const (
+	colorNone colorMode = iota // markup is stripped
+	colorANSI                  // 16 ANSI colors
+	color256                   // xterm 256 colors
+	colorTrue                  // 24-bit color
+)
+
+func (m colorMode) String() string {
+	for name, mode := range colorModeNames {
+		if mode == m {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// colorAuto is the "color" property value that selects the mode
+// detected from the client.
+const colorAuto = "auto"
+
+// colorModeNames maps the values of the "color" property to color
+// modes.
+var colorModeNames = map[string]colorMode{
+	"off":       colorNone,
+	"ansi":      colorANSI,
+	"256":       color256,
+	"truecolor": colorTrue,
+}
+
+// The SGR foreground color codes of the markup color letters.
+var markupColors = map[byte]int{
+	'k': 30, 'r': 31, 'g': 32, 'y': 33, 'b': 34, 'm': 35, 'c': 36, 'w': 37,
+	'K': 90, 'R': 91, 'G': 92, 'Y': 93, 'B': 94, 'M': 95, 'C': 96, 'W': 97,
+}
+
+// The RGB values of the 16 ANSI colors, in SGR code order, used to
+// approximate hex colors on ANSI-only clients.
+var ansiPalette = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// renderMarkup replaces the color markup in `s` with the escape
+// sequences for color mode `mode`.
+func renderMarkup(s string, mode colorMode) string {
+	if strings.IndexByte(s, '{') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		tag, n := markupTag(s[i:])
+		switch {
+		case n == 0:
+			b.WriteByte(s[i])
+			i++
+		case tag == "{":
+			b.WriteByte('{')
+			i += n
+		default:
+			if mode != colorNone {
+				b.WriteString(markupSGR(tag, mode))
+			}
+			i += n
+		}
+	}
+	return b.String()
+}
+
+// escapeMarkup escapes the braces in `s` so that text typed by a
+// player is output as is.
+func escapeMarkup(s string) string {
+	return strings.Replace(s, "{", "{{", -1)
+}
+
+// markupTag checks whether `s` begins with a color markup tag. It
+// returns the tag's contents ("{" for an escaped brace) and its
+// length, or a length of 0 if `s` doesn't begin with a tag.
+func markupTag(s string) (tag string, n int) {
+	if len(s) < 2 || s[0] != '{' {
+		return "", 0
+	}
+	if s[1] == '{' {
+		return "{", 2
+	}
+
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return "", 0
+	}
+	tag = s[1:end]
+	switch {
+	case tag == "x":
+	case len(tag) == 1 && markupColors[tag[0]] != 0:
+	case len(tag) == 7 && tag[0] == '#':
+		if _, err := strconv.ParseUint(tag[1:], 16, 32); err != nil {
+			return "", 0
+		}
+	default:
+		return "", 0
+	}
+	return tag, end + 1
+}
+
+// markupSGR returns the escape sequence for a markup tag.
+func markupSGR(tag string, mode colorMode) string {
+	if tag == "x" {
+		return "\x1b[0m"
+	}
+	if len(tag) == 1 {
+		return fmt.Sprintf("\x1b[%dm", markupColors[tag[0]])
+	}
+
+	v, _ := strconv.ParseUint(tag[1:], 16, 32)
+	r, g, b := int(v>>16), int(v>>8&0xff), int(v&0xff)
+	switch mode {
+	case colorTrue:
+		return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", r, g, b)
+	case color256:
+		return fmt.Sprintf("\x1b[38;5;%dm", xterm256(r, g, b))
+	default:
+		i := nearestANSI(r, g, b)
+		if i < 8 {
+			return fmt.Sprintf("\x1b[%dm", 30+i)
+		}
+		return fmt.Sprintf("\x1b[%dm", 90+i-8)
+	}
+}
+
+// xterm256 returns the xterm 256-color palette index closest to an
+// RGB color, using the 6x6x6 color cube or the grayscale ramp.
+func xterm256(r, g, b int) int {
+	level := func(v int) int {
+		switch {
+		case v < 48:
+			return 0
+		case v < 115:
+			return 1
+		}
+		return (v - 35) / 40
+	}
+
+	if r == g && g == b {
+		switch {
+		case r < 8:
+			return 16
+		case r > 248:
+			return 231
+		}
+		return 232 + (r-8)*24/241
+	}
+	return 16 + 36*level(r) + 6*level(g) + level(b)
+}
+
+// nearestANSI returns the index of the ANSI palette color closest to
+// an RGB color.
+func nearestANSI(r, g, b int) int {
+	best, bestDist := 0, -1
+	for i, c := range ansiPalette {
+		dr, dg, db := r-c[0], g-c[1], b-c[2]
+		dist := dr*dr + dg*dg + db*db
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// colorModeFromTerminal guesses the color mode supported by a
+// terminal from its terminal type name, as reported by telnet
+// TTYPE or the TERM environment variable.
+func colorModeFromTerminal(name string) (colorMode, bool) {
+	name = strings.ToUpper(name)
+	switch {
+	case name == "" || name == "DUMB":
+		return colorNone, false
+	case strings.Contains(name, "TRUECOLOR"):
+		return colorTrue, true
+	case strings.Contains(name, "256COLOR"):
+		return color256, true
+	case strings.HasPrefix(name, "MTTS "):
+		// The Mud Terminal Type Standard reports a bit vector
+		// of client capabilities.
+		bits, err := strconv.Atoi(name[5:])
+		switch {
+		case err != nil:
+			return colorNone, false
+		case bits&256 != 0:
+			return colorTrue, true
+		case bits&8 != 0:
+			return color256, true
+		case bits&1 != 0:
+			return colorANSI, true
+		}
+		return colorNone, true
+	case strings.Contains(name, "ANSI"), strings.Contains(name, "XTERM"),
+		strings.Contains(name, "VT100"), strings.Contains(name, "LINUX"):
+		return colorANSI, true
+	}
+	return colorNone, false
+}
@@ -0,0 +1,88 @@
+package unimud
+
+import (
+	"testing"
+)
+
+func TestRenderMarkup(t *testing.T) {
+	tests := []struct {
+		s    string
+		mode colorMode
+		want string
+	}{
+		{"plain text", colorANSI, "plain text"},
+		{"{r}red{x}", colorNone, "red"},
+		{"{r}red{x}", colorANSI, "\x1b[31mred\x1b[0m"},
+		{"{R}bright", color256, "\x1b[91mbright"},
+		{"{{r}", colorANSI, "{r}"},
+		{"{{r}", colorNone, "{r}"},
+		{"{q}", colorANSI, "{q}"},
+		{"{r", colorANSI, "{r"},
+		{"{}", colorANSI, "{}"},
+		{"{#zzzzzz}", colorTrue, "{#zzzzzz}"},
+		{"{#ff0000}", colorNone, ""},
+		{"{#ff0000}", colorANSI, "\x1b[91m"},
+		{"{#ff0000}", color256, "\x1b[38;5;196m"},
+		{"{#ff0000}", colorTrue, "\x1b[38;2;255;0;0m"},
+		{"{#808080}", color256, "\x1b[38;5;243m"},
+		{"{#000000}", color256, "\x1b[38;5;16m"},
+	}
+	for _, tt := range tests {
+		if got := renderMarkup(tt.s, tt.mode); got != tt.want {
+			t.Errorf("renderMarkup(%q, %v) = %q, want %q", tt.s, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeMarkup(t *testing.T) {
+	for _, s := range []string{"", "{r}", "{{", "a {#ff0000} b {x}", "{"} {
+		for _, mode := range []colorMode{colorNone, colorANSI, color256, colorTrue} {
+			if got := renderMarkup(escapeMarkup(s), mode); got != s {
+				t.Errorf("escaped %q rendered as %q in mode %v", s, got, mode)
+			}
+		}
+	}
+}
+
+func TestColorModeFromTerminal(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  colorMode
+		known bool
+	}{
+		{"", colorNone, false},
+		{"dumb", colorNone, false},
+		{"unknown", colorNone, false},
+		{"xterm", colorANSI, true},
+		{"ANSI", colorANSI, true},
+		{"xterm-256color", color256, true},
+		{"xterm-truecolor", colorTrue, true},
+		{"MTTS 0", colorNone, true},
+		{"MTTS 1", colorANSI, true},
+		{"MTTS 9", color256, true},
+		{"MTTS 265", colorTrue, true},
+		{"MTTS x", colorNone, false},
+	}
+	for _, tt := range tests {
+		mode, known := colorModeFromTerminal(tt.name)
+		if mode != tt.mode || known != tt.known {
+			t.Errorf("colorModeFromTerminal(%q) = %v, %v, want %v, %v",
+				tt.name, mode, known, tt.mode, tt.known)
+		}
+	}
+}
+
+func TestTellMarkup(t *testing.T) {
+	_, addr := newTestGame(t, nil)
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+	b.send("set color ansi")
+	b.expect("color set to ansi.")
+
+	// Markup typed by a player is shown as typed.
+	a.send("tell {r}bobby hello")
+	a.expect("Player {r}bobby not logged in.")
+	a.send("tell bobby {r}hello")
+	a.expect("Message sent to bobby.")
+	b.expect("\x1b[35malice whispers, '{r}hello'.\x1b[0m")
+}
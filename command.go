@@ -1,9 +1,11 @@
 package unimud
 
 import (
-	"errors"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/beevik/prefixtree"
 )
@@ -16,6 +18,7 @@ type command struct {
 }
 
 var commandList = []command{
+	{"client", (*player).cmdClient},
 	{"e", (*player).cmdEast},
 	{"east", (*player).cmdEast},
 	{"go", (*player).cmdGo},
@@ -25,9 +28,12 @@ var commandList = []command{
 	{"quit", (*player).cmdQuit},
 	{"reply", (*player).cmdReply},
 	{"s", (*player).cmdSouth},
+	{"save", (*player).cmdSave},
 	{"say", (*player).cmdSay},
+	{"set", (*player).cmdSet},
 	{"shutdown", (*player).cmdShutdown},
 	{"south", (*player).cmdSouth},
+	{"sshkey", (*player).cmdSSHKey},
 	{"tell", (*player).cmdTell},
 	{"w", (*player).cmdWest},
 	{"west", (*player).cmdWest},
@@ -45,6 +51,36 @@ func init() {
 	}
 }
 
+func (p *player) cmdClient(arg string) error {
+	p.lock.Lock()
+	term, width, height := p.ttypeLast, p.wrapWidth(), p.height
+	p.lock.Unlock()
+
+	if term == "" {
+		term = "unknown"
+	}
+	p.Printf("Terminal:    %s\n", term)
+	p.Printf("Charset:     %v\n", p.clientCharset())
+	p.Printf("Color:       %v\n", p.colorMode())
+	p.Printf("Screen size: %dx%d\n", width, height)
+
+	written, sent, on := p.compression()
+	switch {
+	case on && written > 0:
+		p.Printf("Compression: on, %d bytes sent as %d (%.1f%%)\n",
+			written, sent, 100*float64(sent)/float64(written))
+	default:
+		p.Println("Compression: off")
+	}
+
+	qs := p.queueStats()
+	p.Printf("Output:      %d bytes written, %d queued (peak %d)\n", qs.Written, qs.Queued, qs.Peak)
+	if qs.Overflows > 0 {
+		p.Printf("             %d bytes dropped in %d overflows\n", qs.Dropped, qs.Overflows)
+	}
+	return nil
+}
+
 func (p *player) cmdEast(arg string) error {
 	return p.cmdGo("east")
 }
@@ -80,12 +116,12 @@ func (p *player) cmdNorth(arg string) error {
 
 func (p *player) cmdQuit(arg string) error {
 	p.Println("Quitting the game.")
-	return errors.New("player: disconnecting")
+	return errQuit
 }
 
 func (p *player) cmdReply(arg string) error {
-	name, ok := p.properties["replyto"].(string)
-	if !ok || name == "" {
+	name := p.propString("replyto")
+	if name == "" {
 		p.Println("No one has whispered to you.")
 		return nil
 	}
@@ -93,6 +129,16 @@ func (p *player) cmdReply(arg string) error {
 	return p.cmdTell(name + " " + arg)
 }
 
+func (p *player) cmdSave(arg string) error {
+	if err := p.save(); err != nil {
+		log.Printf("Player %s failed to save: %v\n", p.login, err)
+		p.Println("error: your character couldn't be saved.")
+		return nil
+	}
+	p.Println("Saved.")
+	return nil
+}
+
 func (p *player) cmdSay(arg string) error {
 	if arg == "" {
 		p.Println("Syntax: say <message>")
@@ -104,23 +150,80 @@ func (p *player) cmdSay(arg string) error {
 		return nil
 	}
 
-	p.Printf("You say, '%s'.\n", arg)
+	text := escapeMarkup(arg)
+	p.Printf("{c}You say, '%s'.{x}\n", text)
 	for _, op := range p.room.players {
 		if p != op {
-			op.Printf("%s says, '%s'.\n", p.login, arg)
+			op.Printf("{c}%s says, '%s'.{x}\n", p.login, text)
 		}
+		op.sendChannelText("say", p.login, arg)
+	}
+	return nil
+}
+
+func (p *player) cmdSet(arg string) error {
+	if arg == "" {
+		for _, d := range propertyList {
+			if d.visibility != propHidden {
+				p.Printf("%-10s %v\n", d.name, p.properties[d.name])
+			}
+		}
+		return nil
+	}
+
+	split := strings.SplitN(arg, " ", 2)
+	d, ok := propertyDefs[split[0]]
+	switch {
+	case !ok || d.visibility == propHidden:
+		p.Println("No such setting.")
+		return nil
+	case len(split) < 2:
+		p.Printf("%s is %v.\n", d.name, p.properties[d.name])
+		return nil
+	case d.visibility != propEditable:
+		p.Printf("You can't change %s.\n", d.name)
+		return nil
+	}
+
+	v, err := d.parseValue(strings.TrimSpace(split[1]))
+	if err != nil {
+		p.Printf("%v.\n", err)
+		return nil
 	}
+	p.setProp(d, v)
+	p.applySettings()
+	p.Printf("%s set to %v.\n", d.name, v)
 	return nil
 }
 
 func (p *player) cmdShutdown(arg string) error {
-	// Yield control back to game goroutine
-	// just before shutting it down. Otherwise
-	// it won't be able to process the channel
-	// update.
-	p.yield()
-	p.game.Shutdown()
-	p.resume()
+	split := strings.SplitN(arg, " ", 2)
+
+	if split[0] == "cancel" {
+		if !p.game.shutdownCancel() {
+			p.Println("No shutdown is pending.")
+		}
+		return nil
+	}
+
+	seconds := 0
+	if split[0] != "" {
+		var err error
+		seconds, err = strconv.Atoi(split[0])
+		if err != nil || seconds < 0 {
+			p.Println("Syntax: shutdown [seconds] [reason]")
+			p.Println("        shutdown cancel")
+			return nil
+		}
+	}
+
+	var reason string
+	if len(split) == 2 {
+		reason = stripLeadingWhitespace(split[1])
+	}
+
+	// The shutdown itself happens on the game's next clock tick.
+	p.game.shutdownBegin(time.Duration(seconds)*time.Second, reason)
 	return nil
 }
 
@@ -128,6 +231,66 @@ func (p *player) cmdSouth(arg string) error {
 	return p.cmdGo("south")
 }
 
+func (p *player) cmdSSHKey(arg string) error {
+	keys := sshKeyList(p.propString("sshkeys"))
+	split := strings.SplitN(arg, " ", 2)
+
+	switch {
+	case arg == "":
+		if len(keys) == 0 {
+			p.Println("You have no SSH keys.")
+		}
+		for i, k := range keys {
+			if _, fp, err := parseSSHKey(k); err == nil {
+				p.Printf("%d: %s\n", i+1, fp)
+			}
+		}
+
+	case split[0] == "add" && len(split) == 2:
+		key, fp, err := parseSSHKey(split[1])
+		if err != nil {
+			p.Println("That isn't a valid SSH public key.")
+			return nil
+		}
+		keys = append(keys, key)
+		if !p.saveSSHKeys(keys) {
+			return nil
+		}
+		p.Printf("Added SSH key %s.\n", fp)
+
+	case split[0] == "remove" && len(split) == 2:
+		n, err := strconv.Atoi(strings.TrimSpace(split[1]))
+		if err != nil || n < 1 || n > len(keys) {
+			p.Println("No such SSH key.")
+			return nil
+		}
+		keys = append(keys[:n-1], keys[n:]...)
+		if !p.saveSSHKeys(keys) {
+			return nil
+		}
+		p.Printf("Removed SSH key %d.\n", n)
+
+	default:
+		p.Println("Syntax: sshkey")
+		p.Println("        sshkey add <public key>")
+		p.Println("        sshkey remove <number>")
+	}
+	return nil
+}
+
+// saveSSHKeys replaces the player's SSH keys and saves the player
+// at once, since SSH logins check keys against the player store. It
+// returns false if the player couldn't be saved.
+func (p *player) saveSSHKeys(keys []string) bool {
+	p.setPropString("sshkeys", strings.Join(keys, "\n"))
+	if err := p.save(); err != nil {
+		log.Printf("Player %s failed to save: %v\n", p.login, err)
+		p.Println("error: your SSH keys couldn't be saved.")
+		return false
+	}
+	return true
+}
+
 func (p *player) cmdTell(arg string) error {
 	split := strings.SplitN(arg, " ", 2)
 	if len(split) < 2 {
@@ -138,13 +301,16 @@ func (p *player) cmdTell(arg string) error {
 	op := p.game.playerMap[split[0]]
 	switch {
 	case op == nil:
-		p.Println("Player", split[0], "not logged in.")
+		p.Println("Player", escapeMarkup(split[0]), "not logged in.")
 	case op == p:
 		p.Println("See a psychiatrist.")
 	default:
 		p.Printf("Message sent to %s.\n", split[0])
-		op.Printf("%s whispers, '%s'.\n", p.login, stripLeadingWhitespace(split[1]))
-		op.properties["replyto"] = p.login
+		msg := stripLeadingWhitespace(split[1])
+		op.Printf("{m}%s whispers, '%s'.{x}\n", p.login, escapeMarkup(msg))
+		p.sendChannelText("tell", p.login, msg)
+		op.sendChannelText("tell", p.login, msg)
+		op.setPropString("replyto", p.login)
 	}
 	return nil
 }
@@ -154,19 +320,24 @@ func (p *player) cmdWest(arg string) error {
 }
 
 func (p *player) cmdWho(arg string) error {
+	var logins []string
 	for login := range p.game.playerMap {
-		p.Println(login)
+		logins = append(logins, login)
 	}
+	sort.Strings(logins)
+	p.Page(strings.Join(logins, "\n") + "\n")
 	return nil
 }
 
 func (p *player) cmdYell(arg string) error {
+	text := escapeMarkup(arg)
 	for _, op := range p.game.playerMap {
 		if p == op {
-			op.Printf("You yelled, '%s'.\n", arg)
+			op.Printf("{Y}You yelled, '%s'.{x}\n", text)
 		} else {
-			op.Printf("%s yelled, '%s'.\n", p.login, arg)
+			op.Printf("{Y}%s yelled, '%s'.{x}\n", p.login, text)
 		}
+		op.sendChannelText("yell", p.login, arg)
 	}
 	return nil
 }
@@ -0,0 +1,173 @@
+package unimud
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A Config holds the settings used to create a Game. It is usually
+// loaded from a JSON file with LoadConfig.
+type Config struct {
+	PlayerDir string // directory containing player files
+	RoomDir   string // directory containing room files
+	DBFile    string // database file holding players and rooms (overrides PlayerDir and RoomDir)
+
+	Console   bool             // listen for a player on the console
+	Listeners []ListenerConfig // network addresses on which to accept players
+
+	StartRoom      int // room in which new players start
+	LoginMinLen    int // minimum length of a login id
+	LoginMaxLen    int // maximum length of a login id
+	PasswordMinLen int // minimum length of a password
+	PasswordMaxLen int // maximum length of a password
+
+	TickRate      Duration // interval between game clock ticks
+	AutosaveEvery Duration // how often changed players are saved (0 to disable)
+	AutosaveBatch int      // maximum number of players autosaved per clock tick
+
+	IdleTimeout     Duration // how long a player may go without entering input (0 to disable)
+	IdleWarning     Duration // how long before the idle timeout the player is warned
+	LinkDeadTimeout Duration // how long a player whose connection dropped stays in the game
+	ReadTimeout     Duration // how long a network connection may send nothing before it's treated as dropped, longer than IdleTimeout (0 to disable)
+	KeepAlive       Duration // interval between TCP keepalive probes, which detect silently dropped connections (0 to disable)
+
+	OutputQueueSize  int    // maximum bytes of output waiting to be sent to a player
+	OutputOverflow   string // what to do when a player's output queue is full: "disconnect" or "drop"
+	CompressionLevel int    // zlib level of MCCP compressed output, from 7 (fastest) to 9 (smallest); lower levels expand output
+}
+
+// DefaultConfig returns the default game configuration.
+func DefaultConfig() Config {
+	return Config{
+		PlayerDir:      "players",
+		RoomDir:        "rooms",
+		Listeners:      []ListenerConfig{{Addr: ":2000"}},
+		StartRoom:      0,
+		LoginMinLen:    4,
+		LoginMaxLen:    32,
+		PasswordMinLen: 4,
+		PasswordMaxLen: 32,
+		TickRate:       Duration(time.Second),
+		AutosaveEvery:  Duration(5 * time.Minute),
+		AutosaveBatch:  10,
+
+		IdleTimeout:     Duration(30 * time.Minute),
+		IdleWarning:     Duration(time.Minute),
+		LinkDeadTimeout: Duration(3 * time.Minute),
+		KeepAlive:       Duration(30 * time.Second),
+
+		OutputQueueSize:  256 * 1024,
+		OutputOverflow:   "disconnect",
+		CompressionLevel: defaultCompressionLevel,
+	}
+}
+
+// LoadConfig reads a JSON configuration file. Settings missing from
+// the file keep their default values.
+func LoadConfig(filename string) (Config, error) {
+	cfg := DefaultConfig()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("config %s: %v", filename, err)
+	}
+	return cfg, cfg.validate()
+}
+
+// validate checks the configuration for nonsensical settings.
+func (c *Config) validate() error {
+	switch {
+	case c.LoginMinLen < 1 || c.LoginMaxLen < c.LoginMinLen:
+		return errors.New("config: bad login length limits")
+	case c.PasswordMinLen < 1 || c.PasswordMaxLen < c.PasswordMinLen:
+		return errors.New("config: bad password length limits")
+	case c.TickRate <= 0:
+		return errors.New("config: tick rate must be positive")
+	case c.IdleTimeout < 0 || c.IdleWarning < 0 || c.LinkDeadTimeout < 0 ||
+		c.ReadTimeout < 0 || c.KeepAlive < 0:
+		return errors.New("config: timeouts can't be negative")
+	case c.IdleTimeout > 0 && c.IdleWarning >= c.IdleTimeout:
+		return errors.New("config: idle warning must come before the idle timeout")
+	case c.ReadTimeout > 0 && c.IdleTimeout > 0 && c.ReadTimeout <= c.IdleTimeout:
+		// Otherwise idle players are cut off by the read timeout
+		// without being warned.
+		return errors.New("config: read timeout must be longer than the idle timeout")
+	case c.OutputQueueSize < 1024:
+		return errors.New("config: output queue size must be at least 1024")
+	case c.CompressionLevel < minCompressionLevel || c.CompressionLevel > zlib.BestCompression:
+		return fmt.Errorf("config: compression level must be from %d to %d",
+			minCompressionLevel, zlib.BestCompression)
+	}
+	if _, ok := overflowPolicyNames[c.OutputOverflow]; !ok {
+		return fmt.Errorf("config: unknown output overflow policy %q", c.OutputOverflow)
+	}
+	for _, lc := range c.Listeners {
+		if lc.Addr == "" {
+			return errors.New("config: listener has no address")
+		}
+		if lc.TLS && !lc.SelfSigned && (lc.CertFile == "" || lc.KeyFile == "") {
+			return fmt.Errorf("config: TLS listener %s needs a certificate and key", lc.Addr)
+		}
+		if lc.SSH && !lc.SelfSigned && lc.HostKeyFile == "" {
+			return fmt.Errorf("config: SSH listener %s needs a host key", lc.Addr)
+		}
+		if lc.SSH && (lc.TLS || lc.WebSocket) {
+			return fmt.Errorf("config: SSH listener %s can't use TLS or WebSocket", lc.Addr)
+		}
+	}
+	return nil
+}
+
+// A ListenerConfig describes a network address on which the game
+// accepts player connections.
+type ListenerConfig struct {
+	Addr        string // TCP address, such as ":2000" or "127.0.0.1:2000"
+	TLS         bool   // true to encrypt connections with TLS
+	CertFile    string // PEM certificate file for TLS
+	KeyFile     string // PEM private key file for TLS
+	SelfSigned  bool   // generate a self-signed certificate or temporary host key if no files are given (for development)
+	WebSocket   bool   // true to serve the browser client and accept WebSocket connections
+	SSH         bool   // true to accept SSH connections
+	HostKeyFile string // PEM private key file used as the SSH host key
+}
+
+// A Duration is a time.Duration that is stored in JSON as a string
+// such as "1s" or "5m", or as a number of seconds.
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		secs, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return fmt.Errorf("config: bad duration %s", b)
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("config: bad duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
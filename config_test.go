@@ -0,0 +1,123 @@
+package unimud
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		check func(cfg Config) bool
+		err   string // part of the expected error, or "" for none
+	}{
+		{"empty", `{}`,
+			func(cfg Config) bool { return cfg.LoginMinLen == 4 && cfg.TickRate == Duration(time.Second) }, ""},
+		{"durations", `{"TickRate": "250ms", "IdleTimeout": 90, "IdleWarning": "1m"}`,
+			func(cfg Config) bool {
+				return cfg.TickRate == Duration(250*time.Millisecond) &&
+					cfg.IdleTimeout == Duration(90*time.Second) &&
+					cfg.IdleWarning == Duration(time.Minute)
+			}, ""},
+		{"fractional seconds", `{"TickRate": 0.5}`,
+			func(cfg Config) bool { return cfg.TickRate == Duration(500*time.Millisecond) }, ""},
+		{"listeners", `{"Listeners": [{"Addr": ":4000"}, {"Addr": ":4001", "TLS": true, "SelfSigned": true}]}`,
+			func(cfg Config) bool { return len(cfg.Listeners) == 2 && cfg.Listeners[1].TLS }, ""},
+		{"unknown setting", `{"TickRat": "1s"}`, nil, "unknown field"},
+		{"bad duration", `{"TickRate": "soon"}`, nil, "bad duration"},
+		{"bad json", `{"TickRate": `, nil, "config"},
+		{"invalid", `{"LoginMinLen": 10, "LoginMaxLen": 5}`, nil, "login length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "unimud.json")
+			if err := os.WriteFile(filename, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadConfig(filename)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("LoadConfig: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("LoadConfig returned %v, want an error containing %q", err, tt.err)
+			case tt.check != nil && !tt.check(cfg):
+				t.Errorf("LoadConfig = %+v", cfg)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("LoadConfig of a missing file returned %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		mod  func(cfg *Config)
+		ok   bool
+	}{
+		{"default", func(cfg *Config) {}, true},
+		{"zero tick rate", func(cfg *Config) { cfg.TickRate = 0 }, false},
+		{"zero login length", func(cfg *Config) { cfg.LoginMinLen = 0 }, false},
+		{"password limits reversed", func(cfg *Config) { cfg.PasswordMaxLen = cfg.PasswordMinLen - 1 }, false},
+		{"negative timeout", func(cfg *Config) { cfg.LinkDeadTimeout = -1 }, false},
+		{"warning after timeout", func(cfg *Config) { cfg.IdleWarning = cfg.IdleTimeout }, false},
+		{"idle timeout disabled", func(cfg *Config) { cfg.IdleTimeout, cfg.IdleWarning = 0, Duration(time.Hour) }, true},
+		{"read timeout before idle timeout", func(cfg *Config) { cfg.ReadTimeout = cfg.IdleTimeout - 1 }, false},
+		{"read timeout at idle timeout", func(cfg *Config) { cfg.ReadTimeout = cfg.IdleTimeout }, false},
+		{"read timeout after idle timeout", func(cfg *Config) { cfg.ReadTimeout = cfg.IdleTimeout + 1 }, true},
+		{"read timeout without idle timeout", func(cfg *Config) { cfg.IdleTimeout, cfg.ReadTimeout = 0, Duration(time.Minute) }, true},
+		{"small output queue", func(cfg *Config) { cfg.OutputQueueSize = 100 }, false},
+		{"unknown overflow policy", func(cfg *Config) { cfg.OutputOverflow = "explode" }, false},
+		{"compression level 9", func(cfg *Config) { cfg.CompressionLevel = 9 }, true},
+		{"compression level 10", func(cfg *Config) { cfg.CompressionLevel = 10 }, false},
+		{"expanding compression level", func(cfg *Config) { cfg.CompressionLevel = 6 }, false},
+		{"listener without address", func(cfg *Config) { cfg.Listeners = []ListenerConfig{{}} }, false},
+		{"TLS without certificate", func(cfg *Config) {
+			cfg.Listeners = []ListenerConfig{{Addr: ":2001", TLS: true}}
+		}, false},
+		{"self-signed TLS", func(cfg *Config) {
+			cfg.Listeners = []ListenerConfig{{Addr: ":2001", TLS: true, SelfSigned: true}}
+		}, true},
+		{"SSH without host key", func(cfg *Config) {
+			cfg.Listeners = []ListenerConfig{{Addr: ":2022", SSH: true}}
+		}, false},
+		{"SSH over WebSocket", func(cfg *Config) {
+			cfg.Listeners = []ListenerConfig{{Addr: ":2022", SSH: true, WebSocket: true, SelfSigned: true}}
+		}, false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		tt.mod(&cfg)
+		if err := cfg.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+
+	cfg := DefaultConfig()
+	cfg.LoginMinLen = 6
+	cfg.PlayerDir = "ignored"
+	g.Reload(&cfg)
+
+	// Directories can't be changed while the game is running.
+	waitFor(t, "the settings to be reloaded", func() bool {
+		return g.settings().LoginMinLen == 6
+	})
+	if dir := g.settings().PlayerDir; dir == "ignored" {
+		t.Errorf("PlayerDir changed to %q by a reload", dir)
+	}
+	c := dialTestGame(t, addr)
+	c.expect("login: ")
+	c.send("alice")
+	c.expect("login id is too short.")
+}
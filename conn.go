@@ -6,15 +6,45 @@ import (
 	"io"
 	"net"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // A conn represents a connection from a player to the game.
 type conn struct {
-	closer io.Closer
-	input  *bufio.Scanner
-	output *bufio.Writer
+	closer      io.Closer
+	input       *bufio.Scanner
+	output      *bufio.Writer
+	telnet      *telnet         // telnet protocol layer (nil if not a telnet connection)
+	echo        func(on bool)   // turns echoing of player input on or off
+	echoOff     bool            // true while echo is suppressed
+	lock        sync.Mutex      // protects the client state below, which telnet negotiation may update
+	colorCaps   colorMode       // color mode supported by the client
+	colorPref   string          // the player's color setting ("auto" or a colorModeNames key)
+	ttypeLast   string          // last terminal type reported by the client
+	ttypeN      int             // number of terminal types requested
+	width       int             // width of the client's screen (0 if the client wraps its own output)
+	height      int             // height of the client's screen (0 if the client scrolls its own output)
+	widthPref   int             // the player's width setting (0 to use the client's width)
+	col         int             // column at which the next output begins
+	gmcpModules map[string]bool // lowercase names of the GMCP modules supported by the client
+	charset     charset         // character set negotiated with the client
+	charsetPref string          // the player's charset setting ("auto" or a charsetNames key)
+	closeOnce   sync.Once       // closes the connection
+	closed      chan struct{}   // closed when the connection closes
+	finished    chan struct{}   // closed once the writer has finished and the connection is closed
+	writeOnce   sync.Once       // starts the output writer
+	out         *outputQueue    // output waiting to be written
 }
 
+// The maximum number of terminal types requested from a telnet
+// client. MTTS clients report their name, terminal type and
+// capabilities in turn.
+const maxTerminalTypes = 4
+
 type nopCloser int
 
 func (c *nopCloser) Close() error {
@@ -25,50 +55,427 @@ func (c *nopCloser) Close() error {
 // newConnConsole creates a new connection using the standard I/O
 // as game input and output.
 func newConnConsole() *conn {
+	caps, _ := colorModeFromTerminal(os.Getenv("TERM"))
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width <= 0 {
+		width = defaultWidth
+	}
+	height, err := strconv.Atoi(os.Getenv("LINES"))
+	if err != nil || height <= 0 {
+		height = defaultHeight
+	}
 	return &conn{
-		closer: new(nopCloser),
-		input:  bufio.NewScanner(os.Stdin),
-		output: bufio.NewWriter(os.Stdout),
+		closer:    new(nopCloser),
+		input:     bufio.NewScanner(os.Stdin),
+		output:    bufio.NewWriter(os.Stdout),
+		closed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+		out:       newOutputQueue(),
+		echo:      consoleEcho,
+		colorCaps: caps,
+		width:     width,
+		height:    height,
+	}
+}
+
+// consoleEcho turns the terminal's echo on or off. It relies on
+// stty, so it does nothing on systems without it.
+func consoleEcho(on bool) {
+	arg := "echo"
+	if !on {
+		arg = "-echo"
 	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	cmd.Run()
 }
 
 // newConnNet creates a new connection using the network connection
-// `nc` for the input and output
-func newConnNet(nc net.Conn) *conn {
-	return &conn{
-		closer: nc,
-		input:  bufio.NewScanner(nc),
-		output: bufio.NewWriter(nc),
+// `nc` for the input and output. The connection speaks the telnet
+// protocol, and compresses output at zlib level `zlevel` if the
+// client supports MCCP.
+func newConnNet(nc net.Conn, zlevel int) *conn {
+	t := newTelnet(nc, nc)
+	c := &conn{
+		closer:    nc,
+		input:     bufio.NewScanner(t),
+		output:    bufio.NewWriter(t),
+		closed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+		out:       newOutputQueue(),
+		telnet:    t,
+		colorCaps: colorANSI, // nearly every MUD client supports ANSI color
+		width:     defaultWidth,
+		height:    defaultHeight,
+	}
+	t.onChange = c.telnetChange
+	t.onSub = c.telnetSub
+
+	// Suppressing go-aheads is harmless, so let the client turn it
+	// on for either side.
+	t.allow(optSGA, true, true)
+	t.enableLocal(optSGA)
+
+	// The server echoes nothing, so claiming the ECHO option makes
+	// the client stop echoing input locally.
+	c.echo = func(on bool) {
+		if on {
+			t.disableLocal(optEcho)
+		} else {
+			t.enableLocal(optEcho)
+		}
+	}
+
+	// Ask for the client's terminal type to learn its color
+	// capabilities.
+	t.allow(optTTYPE, false, true)
+	t.enableRemote(optTTYPE)
+
+	// Ask the client to report its window size.
+	t.allow(optNAWS, false, true)
+	t.enableRemote(optNAWS)
+
+	// Offer to compress output.
+	t.zlevel = zlevel
+	t.allow(optMCCP2, true, false)
+	t.enableLocal(optMCCP2)
+
+	// Offer to agree on a character set. Either side may ask.
+	t.allow(optCharset, true, true)
+	t.enableLocal(optCharset)
+
+	// Offer structured data for client maps and status displays.
+	t.allow(optGMCP, true, false)
+	t.enableLocal(optGMCP)
+
+	return c
+}
+
+// telnetChange is called by the telnet layer when an option is
+// enabled or disabled.
+func (c *conn) telnetChange(opt byte, local, enabled bool) {
+	switch {
+	case opt == optTTYPE && !local && enabled:
+		c.requestTerminalType()
+	case opt == optCharset && local && enabled:
+		c.requestCharset()
+	case opt == optMCCP2 && local && enabled:
+		c.telnet.startCompression()
+	case opt == optMCCP2 && local && !enabled:
+		c.telnet.stopCompression()
+	}
+}
+
+// telnetSub is called by the telnet layer when a subnegotiation
+// arrives from the client.
+func (c *conn) telnetSub(opt byte, data []byte) {
+	switch {
+	case opt == optTTYPE && len(data) > 0 && data[0] == ttypeIS:
+		c.receiveTerminalType(string(data[1:]))
+	case opt == optGMCP:
+		c.receiveGMCP(data)
+	case opt == optCharset && len(data) > 0:
+		c.receiveCharset(data[0], string(data[1:]))
+	case opt == optNAWS && len(data) == 4:
+		c.setSize(int(data[0])<<8|int(data[1]), int(data[2])<<8|int(data[3]))
+	}
+}
+
+// requestTerminalType asks the client to send its next terminal
+// type.
+func (c *conn) requestTerminalType() {
+	c.lock.Lock()
+	c.ttypeN++
+	c.lock.Unlock()
+	c.telnet.sendSub(optTTYPE, []byte{ttypeSEND})
+}
+
+// receiveTerminalType handles a terminal type reported by the
+// client. Terminal types are requested until the client repeats
+// itself, which signals the end of its list.
+func (c *conn) receiveTerminalType(name string) {
+	c.lock.Lock()
+	if mode, ok := colorModeFromTerminal(name); ok && mode > c.colorCaps {
+		c.colorCaps = mode
+	}
+	again := name != c.ttypeLast && c.ttypeN < maxTerminalTypes
+	c.ttypeLast = name
+	c.lock.Unlock()
+
+	if again {
+		c.requestTerminalType()
+	}
+}
+
+// requestCharset offers the client the character sets the game
+// supports.
+func (c *conn) requestCharset() {
+	req := []byte{charsetRequest}
+	for _, name := range charsetOffer {
+		req = append(req, ';')
+		req = append(req, name...)
+	}
+	c.telnet.sendSub(optCharset, req)
+}
+
+// receiveCharset handles a CHARSET subnegotiation from the client.
+func (c *conn) receiveCharset(cmd byte, data string) {
+	switch cmd {
+	case charsetAccepted:
+		if cs, ok := lookupCharset(data); ok {
+			c.lock.Lock()
+			c.charset = cs
+			c.lock.Unlock()
+		}
+
+	case charsetRequest:
+		// The client offers a list of character sets, preceded
+		// by the separator character. An offer of translation
+		// tables, which aren't supported, is skipped along with
+		// its version byte.
+		if strings.HasPrefix(data, "[TTABLE]") && len(data) > 8 {
+			data = data[9:]
+		}
+		if len(data) < 2 {
+			c.telnet.sendSub(optCharset, []byte{charsetRejected})
+			return
+		}
+		for _, name := range strings.Split(data[1:], data[:1]) {
+			if cs, ok := lookupCharset(name); ok {
+				c.lock.Lock()
+				c.charset = cs
+				c.lock.Unlock()
+				c.telnet.sendSub(optCharset, append([]byte{charsetAccepted}, name...))
+				return
+			}
+		}
+		c.telnet.sendSub(optCharset, []byte{charsetRejected})
+	}
+}
+
+// setCharsetPref sets the player's preferred character set, which
+// is either "auto" or one of the names in charsetNames.
+func (c *conn) setCharsetPref(pref string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.charsetPref = pref
+}
+
+// clientCharset returns the character set used to communicate with
+// the client.
+func (c *conn) clientCharset() charset {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cs, ok := lookupCharset(c.charsetPref); ok {
+		return cs
+	}
+	return c.charset
+}
+
+// decodeInput converts a line of input from the client's character
+// set and sanitizes it.
+func (c *conn) decodeInput(line string) string {
+	return sanitizeInput(c.clientCharset().decode(line))
+}
+
+// setColorPref sets the player's preferred color mode, which is
+// either "auto" or one of the names in colorModeNames.
+func (c *conn) setColorPref(pref string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.colorPref = pref
+}
+
+// colorMode returns the color mode used to render output.
+func (c *conn) colorMode() colorMode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if mode, ok := colorModeNames[c.colorPref]; ok {
+		return mode
+	}
+	return c.colorCaps
+}
+
+// setSize records the size of the client's screen, as reported by
+// the client. A dimension of 0 means it is unknown.
+func (c *conn) setSize(width, height int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if width > 0 {
+		c.width = width
+	}
+	if height > 0 {
+		c.height = height
+	}
+}
+
+// setWidthPref sets the width to which the player's output is
+// wrapped, overriding the width reported by the client. A width of
+// 0 uses the client's width.
+func (c *conn) setWidthPref(width int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.widthPref = width
+}
+
+// wrapWidth returns the width to which output is wrapped, or 0 if
+// it isn't wrapped. The caller must hold c.lock.
+func (c *conn) wrapWidth() int {
+	if c.widthPref > 0 {
+		return c.widthPref
 	}
+	return c.width
 }
 
-// Close the connection.
+// pageLines word-wraps `s` as it would be output and splits it into
+// lines. It also returns the number of lines that fit on the
+// client's screen, or 0 if the client scrolls its own output.
+func (c *conn) pageLines(s string) (lines []string, height int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, _ = wrapMarkup(s, c.wrapWidth(), c.col)
+	return strings.SplitAfter(s, "\n"), c.height
+}
+
+// compression returns the number of bytes of output written to the
+// connection and the number sent over the network, along with
+// whether output is currently compressed. Connections without
+// compression report equal counts.
+func (c *conn) compression() (written, sent int64, on bool) {
+	if c.telnet == nil {
+		return 0, 0, false
+	}
+	written, sent = c.telnet.outputStats()
+	return written, sent, c.telnet.compressing()
+}
+
+// inputReceived is called when the player enters a line of input,
+// which leaves the client's cursor at the start of a line.
+func (c *conn) inputReceived() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.col = 0
+}
+
+// newConnWebSocket creates a new connection using the WebSocket
+// `ws` for the input and output.
+func newConnWebSocket(ws *wsConn) *conn {
+	c := &conn{
+		closer:    ws,
+		input:     bufio.NewScanner(ws),
+		output:    bufio.NewWriter(ws),
+		closed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+		out:       newOutputQueue(),
+		colorCaps: colorTrue, // the browser client renders any color
+	}
+
+	// The browser client hides its input when asked to stop
+	// echoing.
+	c.echo = func(on bool) {
+		ws.writeText(fmt.Sprintf(`{"echo":%v}`, on))
+	}
+
+	return c
+}
+
+// Close the connection. Output already queued is written first,
+// unless the client takes too long to accept it.
 func (c *conn) Close() error {
-	return c.closer.Close()
+	c.closeOnce.Do(func() {
+		// Never leave a terminal with echo turned off.
+		c.SetEcho(true)
+		if c.telnet != nil {
+			c.queueControl(func() { c.telnet.stopCompression() })
+		}
+		close(c.closed)
+
+		// The writer closes the underlying connection once the
+		// queue is empty.
+		c.writeOnce.Do(func() {
+			go c.writeLoop()
+		})
+		c.out.close()
+		time.AfterFunc(closeTimeout, func() {
+			c.closer.Close()
+		})
+	})
+	return nil
 }
 
-// Flush the output on the connection.
-func (c *conn) Flush() {
-	c.output.Flush()
+// readLoop reads lines of input from the client and passes each
+// to `deliver` until the connection fails or closes. Reading
+// continues while the player isn't waiting for input, so telnet
+// negotiation is handled promptly.
+func (c *conn) readLoop(deliver func(line string)) {
+	for c.input.Scan() {
+		select {
+		case <-c.closed:
+			return
+		default:
+			deliver(c.input.Text())
+		}
+	}
+}
+
+// SetEcho turns the echoing of player input on or off. Input
+// should be echoed again as soon as possible after turning it off.
+func (c *conn) SetEcho(on bool) {
+	if c.echo == nil || c.echoOff == !on {
+		return
+	}
+	c.queueControl(func() { c.echo(on) })
+	c.echoOff = !on
+}
+
+// localEnabled returns true if the telnet option `opt` has been
+// enabled on the server side of the connection.
+func (c *conn) localEnabled(opt byte) bool {
+	return c.telnet != nil && c.telnet.localEnabled(opt)
+}
+
+// remoteEnabled returns true if the telnet option `opt` has been
+// enabled on the client side of the connection.
+func (c *conn) remoteEnabled(opt byte) bool {
+	return c.telnet != nil && c.telnet.remoteEnabled(opt)
 }
 
 // Print outputs arguments to the player's output writer
 // without appending a trailing carriage return.
 func (c *conn) Print(args ...interface{}) {
-	fmt.Fprint(c.output, args...)
-	c.Flush()
+	c.write(fmt.Sprint(args...))
 }
 
 // Println outputs arguments to the player's output writer
 // and appends a trailing carriage return.
 func (c *conn) Println(args ...interface{}) {
-	fmt.Fprintln(c.output, args...)
-	c.Flush()
+	c.write(fmt.Sprintln(args...))
 }
 
 // Printf outputs a printf-formatted string to the player's
 // output writer.
 func (c *conn) Printf(format string, args ...interface{}) {
-	fmt.Fprintf(c.output, format, args...)
-	c.Flush()
+	c.write(fmt.Sprintf(format, args...))
+}
+
+// write word-wraps `s` to the width of the player's screen, renders
+// its color markup, converts it to the client's character set and
+// queues it for output.
+func (c *conn) write(s string) {
+	c.lock.Lock()
+	s, c.col = wrapMarkup(s, c.wrapWidth(), c.col)
+	c.lock.Unlock()
+	s = renderMarkup(s, c.colorMode())
+	c.queueText(c.clientCharset().encode(s))
+}
+
+// setOutputLimit sets the size of the connection's output queue
+// and what happens when it overflows.
+func (c *conn) setOutputLimit(size int, policy overflowPolicy) {
+	c.out.setLimit(size, policy)
+}
+
+// queueStats returns the metrics of the connection's output queue.
+func (c *conn) queueStats() outputStats {
+	return c.out.getStats()
 }
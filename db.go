@@ -0,0 +1,422 @@
+package unimud
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A DB is a simple embedded key-value database stored in a single
+// file. Values are grouped into named buckets. The file is an
+// append-only log of transactions, and a transaction only takes
+// effect once its commit record has been written and synced. A
+// transaction interrupted by a crash is discarded the next time the
+// database is opened.
+//
+// The entire database is held in memory, so it is only suitable for
+// modest amounts of data.
+type DB struct {
+	lock    sync.Mutex
+	path    string
+	f       *os.File
+	buckets map[string]map[string][]byte
+	size    int64 // size of the log file in bytes
+	live    int64 // approximate size of the live data in bytes
+}
+
+// dbMagic identifies a database file.
+const dbMagic = "unimud-db-1\n"
+
+// Log record operations.
+const (
+	dbOpPut    byte = 1
+	dbOpDelete byte = 2
+	dbOpCommit byte = 3
+)
+
+// The log is compacted when opened if it is more than this many
+// times larger than the live data it contains.
+const dbCompactRatio = 2
+
+var (
+	errDBClosed  = errors.New("db: database is closed")
+	errDBCorrupt = errors.New("db: corrupt record")
+	errDBMagic   = errors.New("db: not a database file")
+)
+
+// A dbOp is a single operation within a transaction.
+type dbOp struct {
+	op     byte
+	bucket string
+	key    string
+	value  []byte
+}
+
+// OpenDB opens the database file at `path`, creating it if it
+// doesn't exist.
+func OpenDB(path string) (*DB, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		path:    path,
+		f:       f,
+		buckets: make(map[string]map[string][]byte),
+	}
+	if err := db.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if db.size > dbCompactRatio*(db.live+int64(len(dbMagic))) {
+		if err := db.compact(); err != nil {
+			db.f.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// replay reads the log file and applies all committed transactions.
+// Anything following the last complete transaction is truncated.
+func (db *DB) replay() error {
+	info, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Initialize a new file.
+	if info.Size() == 0 {
+		if _, err := db.f.WriteString(dbMagic); err != nil {
+			return err
+		}
+		db.size = int64(len(dbMagic))
+		return db.f.Sync()
+	}
+
+	r := bufio.NewReader(db.f)
+	magic := make([]byte, len(dbMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != dbMagic {
+		return errDBMagic
+	}
+
+	var pending []dbOp
+	pos := int64(len(dbMagic))
+	good := pos
+	for {
+		op, n, err := readDBRecord(r)
+		if err != nil {
+			break
+		}
+		pos += n
+		if op.op == dbOpCommit {
+			for _, o := range pending {
+				db.apply(o)
+			}
+			pending = pending[:0]
+			good = pos
+		} else {
+			pending = append(pending, op)
+		}
+	}
+
+	// Discard any partially written transaction.
+	if good < info.Size() {
+		if err := db.f.Truncate(good); err != nil {
+			return err
+		}
+	}
+	db.size = good
+	_, err = db.f.Seek(good, io.SeekStart)
+	return err
+}
+
+// apply performs an operation on the in-memory copy of the data.
+func (db *DB) apply(o dbOp) {
+	b := db.buckets[o.bucket]
+	if old, ok := b[o.key]; ok {
+		db.live -= int64(len(o.bucket) + len(o.key) + len(old))
+	}
+
+	switch o.op {
+	case dbOpPut:
+		if b == nil {
+			b = make(map[string][]byte)
+			db.buckets[o.bucket] = b
+		}
+		b[o.key] = o.value
+		db.live += int64(len(o.bucket) + len(o.key) + len(o.value))
+	case dbOpDelete:
+		delete(b, o.key)
+	}
+}
+
+// compact rewrites the log so that it contains only the live data.
+// The new log is written to a temporary file which then replaces
+// the old one.
+func (db *DB) compact() error {
+	tmp := db.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(dbMagic)
+	var buckets []string
+	for bucket := range db.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		b := db.buckets[bucket]
+		for _, key := range sortedKeys(b) {
+			appendDBRecord(&buf, dbOp{dbOpPut, bucket, key, b[key]})
+		}
+	}
+	appendDBRecord(&buf, dbOp{op: dbOpCommit})
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// The new file has replaced the old one, so use it even if the
+	// rename can't be made durable.
+	db.f.Close()
+	db.f = f
+	db.size = int64(buf.Len())
+	return syncDir(filepath.Dir(db.path))
+}
+
+// syncDir flushes the directory `dir` to disk, so that a file
+// renamed into it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close closes the database file.
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.f == nil {
+		return errDBClosed
+	}
+	err := db.f.Close()
+	db.f = nil
+	return err
+}
+
+// Compact rewrites the database file so that it no longer contains
+// overwritten or deleted data.
+func (db *DB) Compact() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.f == nil {
+		return errDBClosed
+	}
+	return db.compact()
+}
+
+// Get returns the value stored under `key` in `bucket`. The second
+// result is false if there is no such value.
+func (db *DB) Get(bucket, key string) ([]byte, bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	v, ok := db.buckets[bucket][key]
+	return v, ok
+}
+
+// Keys returns the sorted keys of all values in `bucket`.
+func (db *DB) Keys(bucket string) []string {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return sortedKeys(db.buckets[bucket])
+}
+
+// Update runs the function `fn` within a transaction. If `fn`
+// returns nil, all changes it made are committed atomically to the
+// database file. Otherwise they are discarded.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.f == nil {
+		return errDBClosed
+	}
+
+	tx := &Tx{db: db}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, o := range tx.ops {
+		appendDBRecord(&buf, o)
+	}
+	appendDBRecord(&buf, dbOp{op: dbOpCommit})
+
+	// If the transaction can't be made durable, remove it from the
+	// log, so that it is neither overwritten by the next
+	// transaction nor replayed when the database is reopened.
+	if _, err := db.f.Write(buf.Bytes()); err != nil {
+		db.rollback()
+		return err
+	}
+	if err := db.f.Sync(); err != nil {
+		db.rollback()
+		return err
+	}
+	db.size += int64(buf.Len())
+
+	for _, o := range tx.ops {
+		db.apply(o)
+	}
+	return nil
+}
+
+// rollback discards anything written to the log after the last
+// committed transaction.
+func (db *DB) rollback() {
+	db.f.Truncate(db.size)
+	db.f.Seek(db.size, io.SeekStart)
+}
+
+// A Tx is a database transaction. Its changes are only visible to
+// itself until it is committed.
+type Tx struct {
+	db  *DB
+	ops []dbOp
+}
+
+// Get returns the value stored under `key` in `bucket`, including
+// any changes made earlier in the transaction.
+func (tx *Tx) Get(bucket, key string) ([]byte, bool) {
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		o := tx.ops[i]
+		if o.bucket == bucket && o.key == key {
+			return o.value, o.op == dbOpPut
+		}
+	}
+	v, ok := tx.db.buckets[bucket][key]
+	return v, ok
+}
+
+// Put stores `value` under `key` in `bucket`.
+func (tx *Tx) Put(bucket, key string, value []byte) {
+	v := make([]byte, len(value))
+	copy(v, value)
+	tx.ops = append(tx.ops, dbOp{dbOpPut, bucket, key, v})
+}
+
+// Delete removes the value stored under `key` in `bucket`.
+func (tx *Tx) Delete(bucket, key string) {
+	tx.ops = append(tx.ops, dbOp{op: dbOpDelete, bucket: bucket, key: key})
+}
+
+// appendDBRecord encodes a log record and appends it to `buf`. A
+// record consists of its payload length, a CRC-32 of the payload,
+// and the payload itself.
+func appendDBRecord(buf *bytes.Buffer, o dbOp) {
+	var payload []byte
+	payload = append(payload, o.op)
+	payload = appendDBString(payload, []byte(o.bucket))
+	payload = appendDBString(payload, []byte(o.key))
+	payload = appendDBString(payload, o.value)
+
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[4:8], crc32.ChecksumIEEE(payload))
+	buf.Write(hdr[:])
+	buf.Write(payload)
+}
+
+func appendDBString(b, s []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(s)))]...)
+	return append(b, s...)
+}
+
+// readDBRecord reads and decodes a single log record. It returns the
+// decoded operation and the number of bytes read.
+func readDBRecord(r io.Reader) (o dbOp, n int64, err error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return o, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[0:4])
+	if size > 1<<30 {
+		return o, 0, errDBCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return o, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(hdr[4:8]) {
+		return o, 0, errDBCorrupt
+	}
+
+	if len(payload) == 0 {
+		return o, 0, errDBCorrupt
+	}
+	o.op, payload = payload[0], payload[1:]
+
+	var bucket, key []byte
+	if bucket, payload, err = readDBString(payload); err != nil {
+		return o, 0, err
+	}
+	if key, payload, err = readDBString(payload); err != nil {
+		return o, 0, err
+	}
+	if o.value, _, err = readDBString(payload); err != nil {
+		return o, 0, err
+	}
+	o.bucket, o.key = string(bucket), string(key)
+	return o, int64(len(hdr)) + int64(size), nil
+}
+
+func readDBString(b []byte) (s, rest []byte, err error) {
+	n, w := binary.Uvarint(b)
+	if w <= 0 || n > uint64(len(b)-w) {
+		return nil, nil, errDBCorrupt
+	}
+	return b[w : w+int(n)], b[w+int(n):], nil
+}
+
+// sortedKeys returns the keys of a bucket in sorted order.
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
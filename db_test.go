@@ -0,0 +1,252 @@
+package unimud
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB opens a new database in a temporary directory.
+func openTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+// reopenTestDB closes the database and opens it again.
+func reopenTestDB(t *testing.T, db *DB, path string) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// put stores a single value in its own transaction.
+func put(t *testing.T, db *DB, bucket, key, value string) {
+	t.Helper()
+	err := db.Update(func(tx *Tx) error {
+		tx.Put(bucket, key, []byte(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkDB compares the contents of a database bucket with `want`.
+func checkDB(t *testing.T, db *DB, bucket string, want map[string]string) {
+	t.Helper()
+	keys := db.Keys(bucket)
+	if len(keys) != len(want) {
+		t.Errorf("bucket %s has keys %q, want %d keys", bucket, keys, len(want))
+	}
+	for k, v := range want {
+		got, ok := db.Get(bucket, k)
+		if !ok || string(got) != v {
+			t.Errorf("Get(%s, %s) = %q, %v, want %q", bucket, k, got, ok, v)
+		}
+	}
+}
+
+func TestDBReplay(t *testing.T) {
+	db, path := openTestDB(t)
+	put(t, db, "players", "alice", "a1")
+	put(t, db, "players", "bob", "b1")
+	put(t, db, "rooms", "0", "hall")
+	put(t, db, "players", "alice", "a2")
+	err := db.Update(func(tx *Tx) error {
+		tx.Delete("players", "bob")
+		tx.Put("players", "carol", []byte("c1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopenTestDB(t, db, path)
+	defer db.Close()
+	checkDB(t, db, "players", map[string]string{"alice": "a2", "carol": "c1"})
+	checkDB(t, db, "rooms", map[string]string{"0": "hall"})
+}
+
+func TestDBTransactionError(t *testing.T) {
+	db, path := openTestDB(t)
+	put(t, db, "players", "alice", "a1")
+
+	errAbort := errors.New("abort")
+	err := db.Update(func(tx *Tx) error {
+		tx.Put("players", "alice", []byte("a2"))
+		tx.Put("players", "bob", []byte("b1"))
+		if v, ok := tx.Get("players", "alice"); !ok || string(v) != "a2" {
+			t.Errorf("transaction doesn't see its own change: %q, %v", v, ok)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Update returned %v, want %v", err, errAbort)
+	}
+	checkDB(t, db, "players", map[string]string{"alice": "a1"})
+
+	db = reopenTestDB(t, db, path)
+	defer db.Close()
+	checkDB(t, db, "players", map[string]string{"alice": "a1"})
+}
+
+func TestDBPartialTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func() []byte
+	}{
+		{"uncommitted", func() []byte {
+			var buf bytes.Buffer
+			appendDBRecord(&buf, dbOp{dbOpPut, "players", "bob", []byte("b1")})
+			return buf.Bytes()
+		}},
+		{"truncated", func() []byte {
+			var buf bytes.Buffer
+			appendDBRecord(&buf, dbOp{dbOpPut, "players", "bob", []byte("b1")})
+			appendDBRecord(&buf, dbOp{op: dbOpCommit})
+			return buf.Bytes()[:buf.Len()-3]
+		}},
+		{"corrupt", func() []byte {
+			var buf bytes.Buffer
+			appendDBRecord(&buf, dbOp{dbOpPut, "players", "bob", []byte("b1")})
+			appendDBRecord(&buf, dbOp{op: dbOpCommit})
+			b := buf.Bytes()
+			b[10] ^= 0xff
+			return b
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTestDB(t)
+			put(t, db, "players", "alice", "a1")
+			size := db.size
+			db.Close()
+
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(tt.tail())
+			f.Close()
+
+			db, err = OpenDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDB(t, db, "players", map[string]string{"alice": "a1"})
+			if info, _ := os.Stat(path); info.Size() != size {
+				t.Errorf("file size %d, want the partial transaction truncated to %d", info.Size(), size)
+			}
+
+			// New transactions follow the last committed one.
+			put(t, db, "players", "carol", "c1")
+			db = reopenTestDB(t, db, path)
+			defer db.Close()
+			checkDB(t, db, "players", map[string]string{"alice": "a1", "carol": "c1"})
+		})
+	}
+}
+
+func TestDBCompact(t *testing.T) {
+	db, path := openTestDB(t)
+	for i := 0; i < 50; i++ {
+		put(t, db, "players", "alice", string(rune('a'+i%26)))
+	}
+	put(t, db, "players", "bob", "b1")
+	before, _ := os.Stat(path)
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("compacted size %d, want less than %d", after.Size(), before.Size())
+	}
+	if after.Size() != db.size {
+		t.Errorf("file size %d, but db.size is %d", after.Size(), db.size)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// Writes after compaction go to the new file.
+	put(t, db, "players", "carol", "c1")
+	db = reopenTestDB(t, db, path)
+	defer db.Close()
+	checkDB(t, db, "players", map[string]string{"alice": "x", "bob": "b1", "carol": "c1"})
+}
+
+func TestDBCompactOnOpen(t *testing.T) {
+	db, path := openTestDB(t)
+	for i := 0; i < 20; i++ {
+		put(t, db, "players", "alice", "a")
+	}
+	before, _ := os.Stat(path)
+
+	db = reopenTestDB(t, db, path)
+	defer db.Close()
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("size after reopening %d, want less than %d", after.Size(), before.Size())
+	}
+	checkDB(t, db, "players", map[string]string{"alice": "a"})
+}
+
+func TestDBNotADatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	os.WriteFile(path, []byte("something else entirely"), 0644)
+	if _, err := OpenDB(path); err != errDBMagic {
+		t.Errorf("OpenDB returned %v, want %v", err, errDBMagic)
+	}
+}
+
+func TestDBClosed(t *testing.T) {
+	db, _ := openTestDB(t)
+	db.Close()
+	if err := db.Update(func(tx *Tx) error { return nil }); err != errDBClosed {
+		t.Errorf("Update returned %v, want %v", err, errDBClosed)
+	}
+	if err := db.Compact(); err != errDBClosed {
+		t.Errorf("Compact returned %v, want %v", err, errDBClosed)
+	}
+	if err := db.Close(); err != errDBClosed {
+		t.Errorf("Close returned %v, want %v", err, errDBClosed)
+	}
+}
+
+func TestDBRecord(t *testing.T) {
+	tests := []dbOp{
+		{dbOpPut, "players", "alice", []byte("value")},
+		{dbOpPut, "", "", nil},
+		{dbOpDelete, "rooms", "12", nil},
+		{op: dbOpCommit},
+		{dbOpPut, "b", "k", bytes.Repeat([]byte{0xff}, 1000)},
+	}
+	for _, o := range tests {
+		var buf bytes.Buffer
+		appendDBRecord(&buf, o)
+		size := int64(buf.Len())
+		got, n, err := readDBRecord(&buf)
+		switch {
+		case err != nil:
+			t.Errorf("readDBRecord(%v): %v", o, err)
+		case n != size:
+			t.Errorf("readDBRecord(%v) read %d bytes, want %d", o, n, size)
+		case got.op != o.op || got.bucket != o.bucket || got.key != o.key || !bytes.Equal(got.value, o.value):
+			t.Errorf("readDBRecord = %v, want %v", got, o)
+		}
+	}
+}
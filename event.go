@@ -0,0 +1,58 @@
+package unimud
+
+// All game state is owned by the game's Run goroutine. Other
+// goroutines, such as the connection readers, never touch it
+// directly. Instead they post events, which are functions the Run
+// goroutine calls one at a time.
+
+// post queues the event `fn` to be handled by the game's Run
+// goroutine. It must not be called from the Run goroutine itself.
+// Events posted after the game ends are discarded.
+func (g *Game) post(fn func()) {
+	select {
+	case g.events <- fn:
+	case <-g.stopped:
+	}
+}
+
+// async calls `work` on a new goroutine, so that slow work such as
+// password hashing doesn't hold up the game. When `work` returns,
+// `done` is handled by the game's Run goroutine.
+func (g *Game) async(work, done func()) {
+	go func() {
+		work()
+		g.post(done)
+	}()
+}
+
+// serveConn plays the game on the connection `c`. The player's
+// input is read on the calling goroutine, and each line is posted
+// to the game's Run goroutine until the connection closes. If
+// `login` isn't empty, the connection has already authenticated
+// the player's login id.
+func (g *Game) serveConn(c *conn, login string) {
+	g.post(func() {
+		p := newPlayer(g, c)
+		if login != "" {
+			p.login = login
+			p.authenticated = true
+		}
+		g.connAdd(c, p)
+		p.start()
+	})
+
+	c.readLoop(func(line string) {
+		g.post(func() {
+			if p := g.conns[c]; p != nil {
+				p.receive(line)
+			}
+		})
+	})
+
+	g.post(func() {
+		if p := g.conns[c]; p != nil {
+			g.connRemove(c)
+			p.connectionLost()
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package unimud
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPostOrder(t *testing.T) {
+	g, _ := newTestGame(t, nil)
+
+	// Events from one goroutine are handled in the order they were
+	// posted.
+	var got []int
+	for i := 0; i < 100; i++ {
+		i := i
+		g.post(func() { got = append(got, i) })
+	}
+	onGameLoop(g, func() {
+		for i, n := range got {
+			if n != i {
+				t.Fatalf("events handled in order %v", got)
+			}
+		}
+		if len(got) != 100 {
+			t.Errorf("%d events handled, want 100", len(got))
+		}
+	})
+}
+
+func TestPostAfterStop(t *testing.T) {
+	g, _ := newTestGame(t, nil)
+	g.Shutdown()
+	<-g.DoneChan
+
+	done := make(chan struct{})
+	go func() {
+		g.post(func() { t.Error("event handled after the game ended") })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("post blocked after the game ended")
+	}
+}
+
+func TestAsync(t *testing.T) {
+	g, _ := newTestGame(t, nil)
+
+	// The game keeps handling events while the work runs, and the
+	// result is handed back on the game's goroutine.
+	release := make(chan struct{})
+	finished := make(chan int)
+	result := 0
+	g.async(func() {
+		<-release
+		result = 42
+	}, func() {
+		finished <- result
+	})
+	onGameLoop(g, func() {})
+	close(release)
+	select {
+	case n := <-finished:
+		if n != 42 {
+			t.Errorf("done saw result %d, want 42", n)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("done wasn't called")
+	}
+}
+
+func TestInputOrder(t *testing.T) {
+	_, addr := newTestGame(t, nil)
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+
+	// Lines sent together are handled one at a time, in order.
+	var lines string
+	for i := 0; i < 20; i++ {
+		lines += fmt.Sprintf("say %d\r\n", i)
+	}
+	if _, err := a.nc.Write([]byte(lines)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		b.expect(fmt.Sprintf("alice says, '%d'.", i))
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	a := loginTestPlayer(t, addr, "alice")
+
+	// Connections dropped at the login prompt and while waiting
+	// for a password hash leave nothing behind.
+	c := dialTestGame(t, addr)
+	c.expect("login: ")
+	c.nc.Close()
+	c = dialTestGame(t, addr)
+	c.expect("login: ")
+	c.send("carol")
+	c.expect("enter password: ")
+	c.send("secret")
+	c.expect("re-enter password: ")
+	c.send("secret")
+	c.nc.Close()
+
+	a.send("quit")
+	a.closed()
+	waitFor(t, "the connections to be removed", func() bool {
+		var n int
+		onGameLoop(g, func() { n = len(g.conns) + len(g.players) })
+		return n == 0
+	})
+}
@@ -1,6 +1,7 @@
 package unimud
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -11,25 +12,57 @@ import (
 // A Game is an instance of a uniMUD game.
 type Game struct {
 	DoneChan      chan bool          // used to signal that the game's Run goroutine has ended
+	PlayerStore   PlayerStore        // persistent storage for player data
+	RoomStore     RoomStore          // storage for room definitions
+	config        Config             // the game's settings
+	configLock    sync.Mutex         // protects sharedConfig
+	sharedConfig  Config             // copy of the game's settings for goroutines other than Run
 	shutdownChan  chan bool          // used to signal that the game should shut down
-	yieldChan     chan bool          // used to yield control to game Run goroutine
-	resumeReqChan chan chan bool     // used to request resumption of control by another goroutine
+	reloadChan    chan *Config       // used to signal that rooms and settings should be reloaded
+	events        chan func()        // events to be handled by the game's Run goroutine
+	stopped       chan struct{}      // closed when the game's Run goroutine ends
+	conns         map[*conn]*player  // the player using each open connection
 	rooms         map[int]*room      // all loaded rooms
 	players       []*player          // all connected players
 	playerMap     map[string]*player // all players who have entered the game world
 	listeners     []net.Listener     // tracks all known network listeners
 	listenersLock sync.Mutex         // protects the listeners slice
+	tick          uint64             // number of clock ticks since the game started
+	timers        timerHeap          // scheduled timers, ordered by the tick on which they fire
+	timerSeq      uint64             // number of times a timer has been scheduled
+	autosaveTimer *timer             // queues players for autosaving once per autosave period
+	autosaveSaver *timer             // saves a batch of queued players each tick
+	autosaveQueue []*player          // players waiting to be autosaved
+	shutdownTimer *timer             // counts down to a pending shutdown (nil if none)
+	shutdownAt    time.Time          // time of a pending shutdown
+	shutdownNow   bool               // true once a pending shutdown is due
+	shutdownMsg   string             // reason given for the pending shutdown
+	shutdownLast  int                // seconds remaining at the last countdown announcement
+	err           error              // the first error encountered while shutting down
 }
 
-// NewGame creates a new unimud game instance.
-func NewGame() *Game {
+// The number of seconds remaining before a shutdown at which the
+// countdown is announced to players.
+var shutdownAnnouncements = []int{600, 300, 120, 60, 30, 10, 5, 4, 3, 2, 1}
+
+// NewGame creates a new unimud game instance using the settings in
+// `cfg`. Players and rooms are stored in the directories named by
+// the configuration; replace the game's PlayerStore and RoomStore
+// to use other backends.
+func NewGame(cfg Config) *Game {
 	return &Game{
-		DoneChan:      make(chan bool),
-		shutdownChan:  make(chan bool),
-		yieldChan:     make(chan bool),
-		resumeReqChan: make(chan chan bool),
-		rooms:         make(map[int]*room),
-		playerMap:     make(map[string]*player),
+		DoneChan:     make(chan bool),
+		PlayerStore:  NewFilePlayerStore(cfg.PlayerDir),
+		RoomStore:    NewFileRoomStore(cfg.RoomDir),
+		config:       cfg,
+		sharedConfig: cfg,
+		shutdownChan: make(chan bool),
+		reloadChan:   make(chan *Config),
+		events:       make(chan func(), 256),
+		stopped:      make(chan struct{}),
+		conns:        make(map[*conn]*player),
+		rooms:        make(map[int]*room),
+		playerMap:    make(map[string]*player),
 	}
 }
 
@@ -38,26 +71,57 @@ func NewGame() *Game {
 func (g *Game) ListenConsole() {
 	fmt.Println("Listening on the console")
 	for {
-		p := newPlayer(g, newConnConsole())
-		p.run()
+		g.serveConn(newConnConsole(), "")
 	}
 }
 
-// ListenNet begins listening for new player connections on
-// the specified port.
-func (g *Game) ListenNet(port int) {
-	// Start listening on the requested TCP port.
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// Listen begins listening for new player connections on the
+// address described by `lc`. Connections are accepted on a new
+// goroutine, so Listen returns as soon as the listener is bound. It
+// returns an error if the listener can't be created.
+func (g *Game) Listen(lc ListenerConfig) error {
+	switch {
+	case lc.SSH:
+		return g.ListenSSH(lc)
+	case lc.WebSocket:
+		return g.ListenWebSocket(lc)
+	}
+
+	var tlsConfig *tls.Config
+	if lc.TLS {
+		var err error
+		if tlsConfig, err = lc.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
+	// Start listening on the requested TCP address.
+	l, err := g.listenTCP(lc.Addr)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
 	}
-	defer l.Close()
 
-	fmt.Println("Listening on port", port)
+	if lc.TLS {
+		fmt.Println("Listening on", lc.Addr, "(TLS)")
+	} else {
+		fmt.Println("Listening on", lc.Addr)
+	}
 
 	// Track the listener.
 	g.listenerAdd(l)
 
+	go g.serve(l, lc.Addr)
+	return nil
+}
+
+// serve accepts connections on the listener `l` until the listener
+// is closed.
+func (g *Game) serve(l net.Listener, addr string) {
+	defer l.Close()
+
 	// Start an infinite loop that waits for new client
 	// connections and creates players and associated goroutines
 	// as connections arrive.
@@ -65,13 +129,23 @@ func (g *Game) ListenNet(port int) {
 		// Block while waiting for a client connection.
 		c, err := l.Accept()
 		if err != nil {
-			log.Printf("Listen on port %d ended.", port)
+			log.Printf("Listen on %s ended.", addr)
 			break
 		}
 
-		// Create a new player on the accepted connection.
-		p := newPlayer(g, newConnNet(c))
-		go p.run()
+		// Create a new player on the accepted connection. The TLS
+		// handshake and telnet negotiation wait on the client, so
+		// they happen on the connection's own goroutine.
+		go func() {
+			if tc, ok := c.(*tls.Conn); ok {
+				if err := tlsHandshake(tc); err != nil {
+					c.Close()
+					return
+				}
+			}
+			armReadTimeout(c)
+			g.serveConn(newConnNet(c, g.settings().CompressionLevel), "")
+		}()
 	}
 
 	g.listenerRemove(l)
@@ -79,8 +153,11 @@ func (g *Game) ListenNet(port int) {
 
 // Run starts the game loop.
 func (g *Game) Run() {
-	// Create a channel that receives the current time once per second
-	clock := time.Tick(1 * time.Second)
+	// Create a ticker that sends the current time once per tick
+	clock := time.NewTicker(time.Duration(g.config.TickRate))
+	defer clock.Stop()
+
+	g.autosaveStart()
 
 mainLoop:
 	for {
@@ -91,23 +168,30 @@ mainLoop:
 			g.onShutdown()
 			break mainLoop
 
-		// Another object is requesting resumption of control
-		// from this goroutine. The channel on which resumption
-		// should be communicated is passed as data through the
-		// channel.
-		case ch := <-g.resumeReqChan:
-			ch <- true    // issue a resume signal through the passed channel.
-			<-g.yieldChan // block until the resumed goroutine yields again.
+		// Wait for reload signal
+		case cfg := <-g.reloadChan:
+			if g.onReload(cfg) {
+				clock.Reset(time.Duration(g.config.TickRate))
+			}
+
+		// Handle player input, timers and other events posted
+		// by other goroutines.
+		case fn := <-g.events:
+			fn()
 
-		// The clock channel ticks sends the current time once per
-		// second
-		case t := <-clock:
-			// TODO: Handle timed events here.
-			_ = t
+		// The clock channel sends the current time once per
+		// tick. Run the timers that are due.
+		case <-clock.C:
+			g.runTimers()
+			if g.shutdownNow {
+				g.onShutdown()
+				break mainLoop
+			}
 		}
 	}
 
 	// Signal on the Done channel that the game has ended.
+	close(g.stopped)
 	g.DoneChan <- true
 }
 
@@ -116,12 +200,238 @@ func (g *Game) Shutdown() {
 	g.shutdownChan <- true
 }
 
+// Reload sends a signal to the game to reload the definitions of
+// all loaded rooms. If `cfg` isn't nil, the game's settings are
+// also updated. Directories and listeners can't be changed while
+// the game is running, so those settings are ignored. A reload
+// requested after the game has ended is discarded.
+func (g *Game) Reload(cfg *Config) {
+	select {
+	case g.reloadChan <- cfg:
+	case <-g.stopped:
+	}
+}
+
+// settings returns a copy of the game's settings. Unlike the
+// config field, which belongs to the Run goroutine, it may be
+// called from any goroutine.
+func (g *Game) settings() Config {
+	g.configLock.Lock()
+	defer g.configLock.Unlock()
+	return g.sharedConfig
+}
+
+// Err returns the first error encountered while shutting down the
+// game, such as a player that couldn't be saved. It should only be
+// called after the game has signaled on DoneChan.
+func (g *Game) Err() error {
+	return g.err
+}
+
 // onShutdown is called when the game's Run goroutine processes
-// the shutdown request.
+// the shutdown request. It stops accepting new connections, then
+// saves and disconnects every player, waiting for their final
+// output to be sent.
 func (g *Game) onShutdown() {
 	g.removeAllListeners()
+
+	// Iterate over a copy, since leaveGame removes the player from
+	// the game's list of players.
+	players := make([]*player, len(g.players))
+	copy(players, g.players)
+	var conns []*conn
+	for _, p := range players {
+		if p.conn != nil {
+			conns = append(conns, p.conn)
+		}
+		p.Println("The game is shutting down. Goodbye!")
+		if err := p.leaveGame(); err != nil && g.err == nil {
+			g.err = err
+		}
+	}
+
+	// Closing a connection only queues its final output, so wait
+	// for the writers to send it, but not for longer than a stalled
+	// client is allowed to hold a connection open.
+	timeout := time.After(closeTimeout)
+	for _, c := range conns {
+		select {
+		case <-c.finished:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// onReload is called when the game's Run goroutine processes the
+// reload request. Each loaded room's definition is read again from
+// the room store. Players in the room stay where they are. It
+// returns true if the clock's tick rate changed.
+func (g *Game) onReload(cfg *Config) bool {
+	tickChanged := false
+	if cfg != nil {
+		autosaveChanged := g.config.AutosaveEvery != cfg.AutosaveEvery
+		idleChanged := g.config.IdleTimeout != cfg.IdleTimeout ||
+			g.config.IdleWarning != cfg.IdleWarning
+		c := g.config
+		c.StartRoom = cfg.StartRoom
+		c.LoginMinLen, c.LoginMaxLen = cfg.LoginMinLen, cfg.LoginMaxLen
+		c.PasswordMinLen, c.PasswordMaxLen = cfg.PasswordMinLen, cfg.PasswordMaxLen
+		c.AutosaveEvery, c.AutosaveBatch = cfg.AutosaveEvery, cfg.AutosaveBatch
+		c.IdleTimeout, c.IdleWarning = cfg.IdleTimeout, cfg.IdleWarning
+		c.LinkDeadTimeout = cfg.LinkDeadTimeout
+		c.OutputQueueSize, c.OutputOverflow = cfg.OutputQueueSize, cfg.OutputOverflow
+		c.ReadTimeout = cfg.ReadTimeout
+		c.CompressionLevel = cfg.CompressionLevel
+		tickChanged = c.TickRate != cfg.TickRate
+		c.TickRate = cfg.TickRate
+		g.config = c
+
+		g.configLock.Lock()
+		g.sharedConfig = c
+		g.configLock.Unlock()
+
+		policy := overflowPolicyNames[c.OutputOverflow]
+		for _, p := range g.players {
+			p.setOutputLimit(c.OutputQueueSize, policy)
+
+			// Restart idle timers with the new settings.
+			// Link-dead players have none.
+			if idleChanged && !p.linkDead {
+				p.resetIdle()
+			}
+		}
+		if autosaveChanged {
+			g.autosaveStart()
+		}
+	}
+
+	for id, r := range g.rooms {
+		nr, err := roomLoad(g, id)
+		if err != nil {
+			log.Printf("Room %d failed to reload: %v\n", id, err)
+			continue
+		}
+		r.Name = nr.Name
+		r.Description = nr.Description
+		r.Exits = nr.Exits
+	}
+	log.Printf("Reloaded %d rooms.\n", len(g.rooms))
+	return tickChanged
+}
+
+// shutdownBegin starts a countdown to shutting down the game,
+// replacing any countdown already pending. The shutdown happens on
+// the first clock tick after `d` has elapsed.
+func (g *Game) shutdownBegin(d time.Duration, reason string) {
+	g.shutdownTimer.Stop()
+	g.shutdownTimer = g.every(time.Second, g.shutdownTick)
+	g.shutdownAt = time.Now().Add(d)
+	g.shutdownMsg = reason
+	g.shutdownLast = 0
+	g.shutdownAnnounce(int(d / time.Second))
+}
+
+// shutdownCancel cancels a pending shutdown. It returns false if no
+// shutdown was pending.
+func (g *Game) shutdownCancel() bool {
+	if g.shutdownTimer == nil {
+		return false
+	}
+	g.shutdownTimer.Stop()
+	g.shutdownTimer = nil
+	g.broadcast("The shutdown has been cancelled.\n")
+	return true
+}
+
+// shutdownTick is called by the shutdown timer. It announces the
+// countdown to a pending shutdown, and tells the game loop to shut
+// down once it is time.
+func (g *Game) shutdownTick() {
+	now := time.Now()
+	if !now.Before(g.shutdownAt) {
+		g.shutdownTimer.Stop()
+		g.shutdownTimer = nil
+		g.shutdownNow = true
+		return
+	}
+
+	remaining := int((g.shutdownAt.Sub(now) + time.Second/2) / time.Second)
+	for _, s := range shutdownAnnouncements {
+		if remaining <= s && (g.shutdownLast == 0 || s < g.shutdownLast) {
+			g.shutdownAnnounce(remaining)
+			break
+		}
+	}
+}
+
+// shutdownAnnounce tells all players how many seconds remain before
+// the game shuts down.
+func (g *Game) shutdownAnnounce(seconds int) {
+	if seconds <= 0 {
+		return
+	}
+	g.shutdownLast = seconds
+
+	msg := fmt.Sprintf("The game will shut down in %d second", seconds)
+	if seconds != 1 {
+		msg += "s"
+	}
+	if g.shutdownMsg != "" {
+		msg += ": " + g.shutdownMsg
+	}
+	g.broadcast(msg + ".\n")
+}
+
+// autosaveStart schedules the autosave timer according to the
+// game's settings, replacing any earlier schedule.
+func (g *Game) autosaveStart() {
+	g.autosaveTimer.Stop()
+	g.autosaveTimer = nil
+	if every := time.Duration(g.config.AutosaveEvery); every > 0 {
+		g.autosaveTimer = g.every(every, g.autosaveQueuePlayers)
+	}
+}
+
+// autosaveQueuePlayers is called once per autosave period. It
+// queues all players with unsaved changes, unless the previous
+// autosave pass is still saving.
+func (g *Game) autosaveQueuePlayers() {
+	if len(g.autosaveQueue) > 0 {
+		return
+	}
 	for _, p := range g.players {
-		p.leaveGame()
+		if p.entered && p.dirty {
+			g.autosaveQueue = append(g.autosaveQueue, p)
+		}
+	}
+	if len(g.autosaveQueue) > 0 {
+		g.autosaveSaver = g.everyTicks(1, g.autosave)
+	}
+}
+
+// autosave is called on every clock tick while players are queued
+// for autosaving. It saves a limited number of queued players per
+// tick so that a large number of saves doesn't stall the game.
+func (g *Game) autosave() {
+	n := len(g.autosaveQueue)
+	if batch := g.config.AutosaveBatch; batch > 0 && n > batch {
+		n = batch
+	}
+	for _, p := range g.autosaveQueue[:n] {
+		// Skip players who left or saved since being queued.
+		if !p.entered || !p.dirty {
+			continue
+		}
+		if err := p.save(); err != nil {
+			log.Printf("Player %s failed to autosave: %v\n", p.login, err)
+		}
+	}
+	g.autosaveQueue = g.autosaveQueue[n:]
+
+	if len(g.autosaveQueue) == 0 {
+		g.autosaveSaver.Stop()
+		g.autosaveSaver = nil
 	}
 }
 
@@ -140,6 +450,17 @@ func (g *Game) playerRemove(p *player) {
 	}
 }
 
+// Record that the player `p` is using the connection `c`.
+func (g *Game) connAdd(c *conn, p *player) {
+	g.conns[c] = p
+}
+
+// Forget the player using the connection `c`. Input still arriving
+// on the connection is ignored.
+func (g *Game) connRemove(c *conn) {
+	delete(g.conns, c)
+}
+
 // Have the player "enter" the game world.
 func (g *Game) playerEnter(p *player) {
 	g.playerMap[p.login] = p
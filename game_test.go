@@ -0,0 +1,339 @@
+package unimud
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRooms is the world used by tests that run a game.
+var testRooms = map[int]string{
+	0: `{"ID":0,"Name":"Hall","Description":"A long hall.","Exits":[{"Name":"north","ID":1}]}`,
+	1: `{"ID":1,"Name":"Attic","Description":"A dusty attic.","Exits":[{"Name":"south","ID":0}]}`,
+}
+
+// testTimeout bounds every wait in tests that run a game.
+const testTimeout = 5 * time.Second
+
+// newTestGame starts a game that stores players in memory and
+// listens for telnet connections on a local port. The settings may
+// be changed by `mod` before the game starts. It returns the game
+// and the address to connect to. The game is shut down when the
+// test ends, unless the test shut it down itself.
+func newTestGame(t *testing.T, mod func(cfg *Config)) (*Game, string) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.TickRate = Duration(20 * time.Millisecond)
+	cfg.PlayerDir = t.TempDir()
+	cfg.RoomDir = t.TempDir()
+	if mod != nil {
+		mod(&cfg)
+	}
+
+	g := NewGame(cfg)
+	g.PlayerStore = NewMemPlayerStore()
+	for id, data := range testRooms {
+		if err := g.RoomStore.Save(id, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := g.listenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.listenerAdd(l)
+	addr := l.Addr().String()
+	go g.serve(l, addr)
+	go g.Run()
+
+	t.Cleanup(func() {
+		select {
+		case g.shutdownChan <- true:
+			<-g.DoneChan
+		case <-g.stopped:
+		}
+	})
+	return g, addr
+}
+
+// onGameLoop calls `fn` on the game's Run goroutine and waits for
+// it to return.
+func onGameLoop(g *Game, fn func()) {
+	done := make(chan struct{})
+	g.post(func() {
+		fn()
+		close(done)
+	})
+	<-done
+}
+
+// waitFor polls `cond` until it returns true. The test fails if it
+// doesn't do so in time.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A testClient is a telnet client connected to a test game. It
+// refuses nothing and agrees to nothing, so no telnet options are
+// ever enabled.
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// dialTestGame connects a new client to the game at `addr`.
+func dialTestGame(t *testing.T, addr string) *testClient {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	return &testClient{t, nc, bufio.NewReader(nc)}
+}
+
+// expect reads the game's output until the text `s` appears, and
+// returns everything read. Telnet commands are skipped.
+func (c *testClient) expect(s string) string {
+	c.t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(testTimeout))
+	var text strings.Builder
+	for !strings.Contains(text.String(), s) {
+		b, err := c.readByte()
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v; got %q", s, err, text.String())
+		}
+		text.WriteByte(b)
+	}
+	return text.String()
+}
+
+// readByte returns the next byte of text from the game.
+func (c *testClient) readByte() (byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil || b != telnetIAC {
+			return b, err
+		}
+
+		cmd, err := c.r.ReadByte()
+		switch {
+		case err != nil:
+			return 0, err
+		case cmd == telnetIAC:
+			return cmd, nil
+		case cmd == telnetSB:
+			// Skip to IAC SE.
+			var last byte
+			for {
+				if b, err = c.r.ReadByte(); err != nil {
+					return 0, err
+				}
+				if last == telnetIAC && b == telnetSE {
+					break
+				}
+				if last == telnetIAC && b == telnetIAC {
+					b = 0 // an escaped IAC
+				}
+				last = b
+			}
+		case cmd >= telnetWILL:
+			if _, err := c.r.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// closed waits for the game to close the connection, ignoring any
+// remaining output.
+func (c *testClient) closed() {
+	c.t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		if _, err := c.r.ReadByte(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.t.Fatal("connection still open")
+			}
+			return
+		}
+	}
+}
+
+// send sends a line of input to the game.
+func (c *testClient) send(line string) {
+	if _, err := c.nc.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// loginTestPlayer connects to the game and logs in as `login` with
+// the password "secret", creating the player if necessary. It
+// returns once the player is at the command prompt.
+func loginTestPlayer(t *testing.T, addr, login string) *testClient {
+	t.Helper()
+	c := dialTestGame(t, addr)
+	c.expect("login: ")
+	c.send(login)
+	if strings.HasSuffix(c.expect("password: "), "enter password: ") {
+		c.send("secret")
+		c.expect("re-enter password: ")
+	}
+	c.send("secret")
+	c.expect("> ")
+	return c
+}
+
+// addTestPlayer stores player `login` with the password "secret".
+// The password is hashed with a single iteration, so logging in is
+// quick even under the race detector, and tests that depend on
+// timing aren't thrown off by it.
+func addTestPlayer(t *testing.T, g *Game, login string) {
+	t.Helper()
+	salt := []byte("0123456789abcdef")
+	key := pbkdf2SHA256([]byte("secret"), salt, 1, pwKeyLen)
+	enc := base64.RawStdEncoding
+	pw := fmt.Sprintf("%s$1$%s$%s", pwHashScheme, enc.EncodeToString(salt), enc.EncodeToString(key))
+	if err := g.PlayerStore.Save(login, map[string]interface{}{"pw": pw, "room": 0}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A recordingStore is a player store that records the login id of
+// every player it saves.
+type recordingStore struct {
+	PlayerStore
+	saved []string
+}
+
+func (s *recordingStore) Save(login string, props map[string]interface{}) error {
+	s.saved = append(s.saved, login)
+	return s.PlayerStore.Save(login, props)
+}
+
+func TestAutosaveBatches(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TickRate = Duration(time.Second)
+	cfg.AutosaveEvery = Duration(3 * time.Second)
+	cfg.AutosaveBatch = 2
+	g := NewGame(cfg)
+	store := &recordingStore{PlayerStore: NewMemPlayerStore()}
+	g.PlayerStore = store
+
+	// Only players in the world with unsaved changes are saved.
+	for _, tp := range []struct {
+		login          string
+		entered, dirty bool
+	}{
+		{"alice", true, true},
+		{"bobby", true, true},
+		{"carol", true, false},
+		{"david", false, true},
+		{"erica", true, true},
+		{"frank", true, true},
+		{"gavin", true, true},
+	} {
+		p := newPlayer(g, nil)
+		p.login, p.entered, p.dirty = tp.login, tp.entered, tp.dirty
+		g.playerAdd(p)
+	}
+	g.autosaveStart()
+
+	var perTick []int
+	for i := 0; i < 9; i++ {
+		store.saved = nil
+		g.runTimers()
+		perTick = append(perTick, len(store.saved))
+	}
+	if want := []int{0, 0, 0, 2, 2, 1, 0, 0, 0}; !reflect.DeepEqual(perTick, want) {
+		t.Errorf("saves per tick %v, want %v", perTick, want)
+	}
+	for _, p := range g.players {
+		if p.entered && p.dirty {
+			t.Errorf("player %s wasn't saved", p.login)
+		}
+	}
+}
+
+func TestAutosave(t *testing.T) {
+	g, addr := newTestGame(t, func(cfg *Config) {
+		cfg.AutosaveEvery = Duration(100 * time.Millisecond)
+	})
+	c := loginTestPlayer(t, addr, "alice")
+	c.send("north")
+	c.expect("Attic")
+
+	// The move is saved without the player leaving the game.
+	waitFor(t, "the player to be autosaved", func() bool {
+		props, err := g.PlayerStore.Load("alice")
+		return err == nil && props["room"] == 1
+	})
+}
+
+func TestShutdownCountdown(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+	b.send("north")
+	b.expect("Attic")
+
+	// A pending shutdown can be cancelled.
+	a.send("shutdown 60")
+	b.expect("The game will shut down in 60 seconds.")
+	a.send("shutdown cancel")
+	b.expect("The shutdown has been cancelled.")
+	a.send("shutdown cancel")
+	a.expect("No shutdown is pending.")
+
+	a.send("shutdown 2 maintenance")
+	b.expect("The game will shut down in 2 seconds: maintenance.")
+	b.expect("The game will shut down in 1 second: maintenance.")
+	for _, c := range []*testClient{a, b} {
+		c.expect("The game is shutting down. Goodbye!")
+		c.closed()
+	}
+	select {
+	case <-g.DoneChan:
+	case <-time.After(testTimeout):
+		t.Fatal("game didn't end")
+	}
+
+	// Both players were saved on the way out.
+	if props, err := g.PlayerStore.Load("bobby"); err != nil || props["room"] != 1 {
+		t.Errorf("bobby saved in room %v, %v, want 1", props["room"], err)
+	}
+	if err := g.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestReloadAfterShutdown(t *testing.T) {
+	g, _ := newTestGame(t, nil)
+	g.Shutdown()
+	<-g.DoneChan
+
+	done := make(chan struct{})
+	go func() {
+		g.Reload(nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("Reload blocked after the game ended")
+	}
+}
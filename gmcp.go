@@ -0,0 +1,184 @@
+package unimud
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The Generic MUD Communication Protocol (GMCP) carries structured
+// data alongside the text stream, for client-side maps and status
+// displays. Each message is a telnet subnegotiation for option 201
+// containing a package name, optionally followed by a space and a
+// JSON value. The client lists the modules it wants with
+// Core.Supports.Set, and packages are only sent for those modules.
+// Package and module names are case-insensitive.
+
+// A gmcpPackage is a GMCP message the server can send.
+type gmcpPackage struct {
+	name   string // full package name, such as "Room.Info"
+	module string // module the package belongs to, such as "Room"
+}
+
+// gmcpPackages maps the lowercase names of all registered packages
+// to their declarations.
+var gmcpPackages = make(map[string]*gmcpPackage)
+
+// registerGMCP declares a GMCP package that the server sends. The
+// package belongs to the module named by all but the last
+// component of its name. Registering a package twice is a
+// programming error, so it panics.
+func registerGMCP(name string) *gmcpPackage {
+	key := strings.ToLower(name)
+	if _, ok := gmcpPackages[key]; ok {
+		panic("gmcp: package " + name + " registered twice")
+	}
+
+	module := name
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		module = name[:i]
+	}
+	pkg := &gmcpPackage{name: name, module: module}
+	gmcpPackages[key] = pkg
+	return pkg
+}
+
+// The packages sent by the game.
+var (
+	gmcpRoomInfo    = registerGMCP("Room.Info")
+	gmcpCharStatus  = registerGMCP("Char.Status")
+	gmcpChannelText = registerGMCP("Comm.Channel.Text")
+)
+
+// gmcpRoomInfoData is the Room.Info package data.
+type gmcpRoomInfoData struct {
+	Num   int            `json:"num"`
+	Name  string         `json:"name"`
+	Exits map[string]int `json:"exits"`
+}
+
+// gmcpCharStatusData is the Char.Status package data.
+type gmcpCharStatusData struct {
+	Name string `json:"name"`
+	Room int    `json:"room"`
+}
+
+// gmcpChannelTextData is the Comm.Channel.Text package data.
+type gmcpChannelTextData struct {
+	Channel string `json:"channel"`
+	Talker  string `json:"talker"`
+	Text    string `json:"text"`
+}
+
+// sendGMCP sends package `pkg` with the JSON encoding of `data` to
+// the client, if the client supports the package's module.
+func (c *conn) sendGMCP(pkg *gmcpPackage, data interface{}) {
+	if !c.localEnabled(optGMCP) || !c.gmcpSupports(pkg.module) {
+		return
+	}
+
+	msg := pkg.name
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("GMCP %s: %v\n", pkg.name, err)
+			return
+		}
+		msg += " " + string(b)
+	}
+
+	// Keep the message in order with the text around it.
+	c.queueControl(func() {
+		c.telnet.sendSub(optGMCP, []byte(msg))
+	})
+}
+
+// gmcpSupports returns true if the client asked for GMCP module
+// `module`.
+func (c *conn) gmcpSupports(module string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.gmcpModules[strings.ToLower(module)]
+}
+
+// receiveGMCP handles a GMCP message from the client.
+func (c *conn) receiveGMCP(data []byte) {
+	msg := string(data)
+	name, arg := msg, ""
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		name, arg = msg[:i], msg[i+1:]
+	}
+
+	switch strings.ToLower(name) {
+	case "core.supports.set":
+		c.gmcpSetModules(arg, true, true)
+	case "core.supports.add":
+		c.gmcpSetModules(arg, false, true)
+	case "core.supports.remove":
+		c.gmcpSetModules(arg, false, false)
+	}
+}
+
+// gmcpSetModules updates the set of modules supported by the client
+// from the Core.Supports list `arg`, whose entries are a module name
+// followed by a version number. If `reset` is true, the list
+// replaces all previously supported modules.
+func (c *conn) gmcpSetModules(arg string, reset, on bool) {
+	var list []string
+	if err := json.Unmarshal([]byte(arg), &list); err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if reset || c.gmcpModules == nil {
+		c.gmcpModules = make(map[string]bool)
+	}
+	for _, entry := range list {
+		var module string
+		var version int
+		fmt.Sscan(entry, &module, &version)
+		if module == "" {
+			continue
+		}
+		module = strings.ToLower(module)
+		if on {
+			c.gmcpModules[module] = true
+		} else {
+			delete(c.gmcpModules, module)
+		}
+	}
+}
+
+// sendRoomInfo sends the Room.Info package describing room `r`.
+func (p *player) sendRoomInfo(r *room) {
+	info := gmcpRoomInfoData{
+		Num:   r.ID,
+		Name:  renderMarkup(r.Name, colorNone),
+		Exits: make(map[string]int, len(r.Exits)),
+	}
+	for _, e := range r.Exits {
+		info.Exits[e.Name] = e.ID
+	}
+	p.sendGMCP(gmcpRoomInfo, info)
+}
+
+// sendCharStatus sends the Char.Status package describing the
+// player.
+func (p *player) sendCharStatus() {
+	p.sendGMCP(gmcpCharStatus, gmcpCharStatusData{
+		Name: p.login,
+		Room: p.propInt("room"),
+	})
+}
+
+// sendChannelText sends the Comm.Channel.Text package for a message
+// from `talker` on `channel`.
+func (p *player) sendChannelText(channel, talker, text string) {
+	p.sendGMCP(gmcpChannelText, gmcpChannelTextData{
+		Channel: channel,
+		Talker:  talker,
+		Text:    text,
+	})
+}
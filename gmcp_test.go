@@ -0,0 +1,124 @@
+package unimud
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGMCPSupports(t *testing.T) {
+	c := &conn{}
+	steps := []struct {
+		msg       string
+		supported []string
+		missing   []string
+	}{
+		{`core.supports.set ["Room 1", "Comm.Channel 1"]`, []string{"Room", "room", "Comm.Channel"}, []string{"Char", "Comm"}},
+		{`Core.Supports.Add ["Char 1"]`, []string{"Room", "Char"}, nil},
+		{`Core.Supports.Remove ["ROOM"]`, []string{"Char", "Comm.Channel"}, []string{"Room"}},
+		{`Core.Supports.Set "Room 1"`, []string{"Char"}, []string{"Room"}},
+		{`Core.Supports.Set ["Room 1"]`, []string{"Room"}, []string{"Char", "Comm.Channel"}},
+		{`Core.Hello {"client": "test"}`, []string{"Room"}, nil},
+	}
+	for _, s := range steps {
+		c.receiveGMCP([]byte(s.msg))
+		for _, m := range s.supported {
+			if !c.gmcpSupports(m) {
+				t.Errorf("after %s: module %s not supported", s.msg, m)
+			}
+		}
+		for _, m := range s.missing {
+			if c.gmcpSupports(m) {
+				t.Errorf("after %s: module %s supported", s.msg, m)
+			}
+		}
+	}
+}
+
+// readGMCP reads the game's output until the text `s` appears, and
+// returns the GMCP messages received on the way.
+func (c *testClient) readGMCP(s string) []string {
+	c.t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(testTimeout))
+	var text strings.Builder
+	var msgs []string
+	for !strings.Contains(text.String(), s) {
+		b, err := c.r.ReadByte()
+		if err == nil && b == telnetIAC {
+			var cmd byte
+			if cmd, err = c.r.ReadByte(); err == nil {
+				switch {
+				case cmd == telnetIAC:
+				case cmd == telnetSB:
+					var sub []byte
+					if sub, err = c.readSub(); err == nil && len(sub) > 0 && sub[0] == optGMCP {
+						msgs = append(msgs, string(sub[1:]))
+					}
+					continue
+				case cmd >= telnetWILL:
+					_, err = c.r.ReadByte()
+					continue
+				default:
+					continue
+				}
+			}
+		}
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v; got %q", s, err, text.String())
+		}
+		text.WriteByte(b)
+	}
+	return msgs
+}
+
+// readSub reads a telnet subnegotiation up to IAC SE and returns its
+// unescaped contents.
+func (c *testClient) readSub() ([]byte, error) {
+	var sub []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != telnetIAC {
+			sub = append(sub, b)
+			continue
+		}
+		if b, err = c.r.ReadByte(); err != nil {
+			return nil, err
+		}
+		if b == telnetSE {
+			return sub, nil
+		}
+		sub = append(sub, b)
+	}
+}
+
+func TestGMCP(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	attic := `{"ID":1,"Name":"{y}Dusty{x} Attic","Description":"A dusty attic.","Exits":[{"Name":"south","ID":0}]}`
+	if err := g.RoomStore.Save(1, []byte(attic)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialTestGame(t, addr)
+	c.nc.Write([]byte{telnetIAC, telnetDO, optGMCP})
+	c.nc.Write([]byte("\xff\xfa\xc9core.supports.set [\"room 1\"]\xff\xf0"))
+	c.expect("login: ")
+	c.send("alice")
+	c.expect("enter password: ")
+	c.send("secret")
+	c.expect("re-enter password: ")
+	c.send("secret")
+	c.expect("> ")
+
+	// Only packages of the modules the client asked for are sent,
+	// and the room name is sent without its markup.
+	c.send("north")
+	c.send("sshkey")
+	msgs := c.readGMCP("You have no SSH keys.")
+	want := `Room.Info {"num":1,"name":"Dusty Attic","exits":{"south":0}}`
+	if len(msgs) != 1 || msgs[0] != want {
+		t.Errorf("received GMCP %q, want %q", msgs, want)
+	}
+}
@@ -0,0 +1,90 @@
+package unimud
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// A client that drops off the network without closing its
+// connection sends nothing more, so reading from the connection
+// would never fail. Keepalive probes notice such connections
+// eventually, and the ReadTimeout setting gives up on a connection
+// that has sent nothing for too long. Either way the read fails and
+// the player's link goes dead.
+
+// The number of unanswered keepalive probes after which a
+// connection is considered dropped.
+const keepAliveProbes = 4
+
+// listenTCP listens on the TCP address `addr`. Connections accepted
+// by the listener send keepalive probes, and their reads time out
+// once armed with armReadTimeout.
+func (g *Game) listenTCP(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: -1}
+	if d := time.Duration(g.settings().KeepAlive); d > 0 {
+		lc.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     d,
+			Interval: d,
+			Count:    keepAliveProbes,
+		}
+	}
+
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &deadlineListener{l, g}, nil
+}
+
+// A deadlineListener accepts connections whose reads can time out.
+type deadlineListener struct {
+	net.Listener
+	game *Game
+}
+
+// Accept waits for the next connection.
+func (l *deadlineListener) Accept() (net.Conn, error) {
+	nc, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &deadlineConn{Conn: nc, game: l.game}, nil
+}
+
+// A deadlineConn is a network connection whose reads fail if nothing
+// arrives within the game's read timeout. The deadline is refreshed
+// before every read. It isn't applied until the connection is armed,
+// so it doesn't interfere with protocol handshakes that set their
+// own deadlines.
+type deadlineConn struct {
+	net.Conn
+	game  *Game
+	armed atomic.Bool
+}
+
+// Read reads data from the connection.
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.armed.Load() {
+		if d := time.Duration(c.game.settings().ReadTimeout); d > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(d))
+		} else {
+			c.Conn.SetReadDeadline(time.Time{})
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+// armReadTimeout starts applying the game's read timeout to the
+// connection `nc`, once it has been handed over to the game.
+func armReadTimeout(nc net.Conn) {
+	if tc, ok := nc.(*tls.Conn); ok {
+		nc = tc.NetConn()
+	}
+	if dc, ok := nc.(*deadlineConn); ok {
+		dc.armed.Store(true)
+	}
+}
@@ -0,0 +1,32 @@
+package unimud
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTimeout(t *testing.T) {
+	g, addr := newTestGame(t, func(cfg *Config) {
+		cfg.IdleTimeout = 0
+		cfg.ReadTimeout = Duration(500 * time.Millisecond)
+		cfg.LinkDeadTimeout = Duration(time.Minute)
+	})
+	addTestPlayer(t, g, "alice")
+	addTestPlayer(t, g, "bobby")
+	loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+
+	// A connection that sends nothing is treated as dropped, while
+	// one that keeps talking stays open.
+	deadline := time.Now().Add(testTimeout)
+	var text strings.Builder
+	for !strings.Contains(text.String(), "alice's link has gone dead.") {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the link to go dead; got %q", text.String())
+		}
+		b.send("save")
+		text.WriteString(b.expect("> "))
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,74 @@
+package unimud
+
+import "compress/zlib"
+
+// The MUD Client Compression Protocol v2 (MCCP2) compresses the
+// server's output with a zlib stream. Once the client agrees to
+// telnet option 86, the server sends IAC SB COMPRESS2 IAC SE, and
+// everything that follows it is compressed until the stream ends.
+
+// The zlib levels used for MCCP. Output is flushed after every
+// write, usually a line or two. At levels 1 to 6, Go's compressor
+// doesn't find matches with earlier flushes in such short writes,
+// so it sends each as a stored block and the output grows. Level 7
+// is the lowest that compresses it, so lower levels aren't allowed.
+const (
+	minCompressionLevel     = 7
+	defaultCompressionLevel = 7
+)
+
+// A telnetWire writes to a telnet's raw output stream, counting the
+// bytes sent over the network. The telnet's wlock must be held.
+type telnetWire struct {
+	t *telnet
+}
+
+func (w telnetWire) Write(b []byte) (int, error) {
+	n, err := w.t.w.Write(b)
+	w.t.wireOut += int64(n)
+	return n, err
+}
+
+// startCompression begins compressing the output stream.
+func (t *telnet) startCompression() error {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+	if t.z != nil {
+		return nil
+	}
+
+	wire := telnetWire{t}
+	if _, err := wire.Write([]byte{telnetIAC, telnetSB, optMCCP2, telnetIAC, telnetSE}); err != nil {
+		return err
+	}
+	t.z, _ = zlib.NewWriterLevel(wire, t.zlevel)
+	return nil
+}
+
+// stopCompression ends the compressed stream, so that output is
+// sent uncompressed again.
+func (t *telnet) stopCompression() error {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+	if t.z == nil {
+		return nil
+	}
+	err := t.z.Close()
+	t.z = nil
+	return err
+}
+
+// compressing returns true while the output stream is compressed.
+func (t *telnet) compressing() bool {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+	return t.z != nil
+}
+
+// outputStats returns the number of bytes of protocol data written
+// and the number of bytes sent over the network after compression.
+func (t *telnet) outputStats() (written, sent int64) {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+	return t.rawOut, t.wireOut
+}
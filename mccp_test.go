@@ -0,0 +1,58 @@
+package unimud
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMCCP(t *testing.T) {
+	for level := minCompressionLevel; level <= zlib.BestCompression; level++ {
+		var out bytes.Buffer
+		tn := newTelnet(nil, &out)
+		tn.zlevel = level
+		tn.Write([]byte("before\n"))
+		if err := tn.startCompression(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Output is flushed a line at a time, as players see it.
+		var want strings.Builder
+		for i := 0; i < 100; i++ {
+			line := fmt.Sprintf("{R}Line %d of the compressed output.{x}\n", i)
+			tn.Write([]byte(line))
+			want.WriteString(strings.Replace(line, "\n", "\r\n", -1))
+		}
+		written, sent := tn.outputStats()
+		if sent >= written {
+			t.Errorf("level %d: %d bytes sent for %d written", level, sent, written)
+		}
+		if err := tn.stopCompression(); err != nil {
+			t.Fatal(err)
+		}
+		tn.Write([]byte("after\n"))
+
+		data := out.Bytes()
+		start := []byte("before\r\n\xff\xfa\x56\xff\xf0")
+		if !bytes.HasPrefix(data, start) {
+			t.Fatalf("level %d: output begins %q", level, data)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[len(start):]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if string(text) != want.String() {
+			t.Errorf("level %d: decompressed %q, want %q", level, text, want.String())
+		}
+		if !bytes.HasSuffix(data, []byte("after\r\n")) {
+			t.Errorf("level %d: output after compression ends %q", level, data[len(data)-10:])
+		}
+	}
+}
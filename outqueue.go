@@ -0,0 +1,232 @@
+package unimud
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Output to a player is queued and written to the connection by a
+// separate goroutine, so a slow or stalled client never blocks the
+// game. The queue is bounded. When a client falls too far behind,
+// either its oldest queued text is dropped or it is disconnected,
+// depending on the overflow policy.
+
+// An overflowPolicy determines what happens when a connection's
+// output queue is full.
+type overflowPolicy int
+
+const (
+	overflowDisconnect overflowPolicy = iota // close the connection
+	overflowDrop                             // drop the oldest queued text
+)
+
+// overflowPolicyNames maps the OutputOverflow setting to overflow
+// policies.
+var overflowPolicyNames = map[string]overflowPolicy{
+	"disconnect": overflowDisconnect,
+	"drop":       overflowDrop,
+}
+
+// The output queue size used until the game's setting is applied.
+const defaultOutputQueueSize = 64 * 1024
+
+// The time allowed for queued output to be written when a
+// connection closes.
+const closeTimeout = 5 * time.Second
+
+var errOutputOverflow = errors.New("conn: output queue overflow")
+
+// An outputItem is an entry in an output queue. It holds either
+// text or a control function, such as a telnet negotiation, that
+// must run in order with the text.
+type outputItem struct {
+	text string
+	ctl  func()
+}
+
+// outputStats holds the metrics of an output queue.
+type outputStats struct {
+	Queued    int   // bytes of text waiting to be written
+	Peak      int   // largest number of bytes ever queued
+	Written   int64 // bytes of text written to the connection
+	Dropped   int64 // bytes of text dropped because the queue was full
+	Overflows int   // number of times the queue was full
+}
+
+// An outputQueue holds a connection's pending output.
+type outputQueue struct {
+	lock   sync.Mutex
+	ready  *sync.Cond     // signaled when items are queued or the queue closes
+	items  []outputItem   // pending output, oldest first
+	limit  int            // maximum bytes of text queued
+	policy overflowPolicy // what to do when the queue is full
+	closed bool           // true once no more output is accepted
+	failed bool           // true once the connection failed or overflowed
+	stats  outputStats    // queue metrics
+}
+
+func newOutputQueue() *outputQueue {
+	q := &outputQueue{limit: defaultOutputQueueSize}
+	q.ready = sync.NewCond(&q.lock)
+	return q
+}
+
+// push adds an item to the queue. It returns errOutputOverflow if
+// the queue is full and its policy is to disconnect.
+func (q *outputQueue) push(item outputItem) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed || q.failed {
+		return nil
+	}
+
+	n := len(item.text)
+	if q.stats.Queued+n > q.limit {
+		q.stats.Overflows++
+		if q.policy == overflowDisconnect {
+			q.failed = true
+			q.items = nil
+			q.stats.Queued = 0
+			q.ready.Broadcast()
+			return errOutputOverflow
+		}
+
+		// Make room by dropping the oldest text. Control items
+		// are small and keep the connection's state consistent,
+		// so they stay.
+		kept := q.items[:0]
+		for _, it := range q.items {
+			if it.ctl == nil && q.stats.Queued+n > q.limit {
+				q.stats.Queued -= len(it.text)
+				q.stats.Dropped += int64(len(it.text))
+				continue
+			}
+			kept = append(kept, it)
+		}
+		q.items = kept
+		if q.stats.Queued+n > q.limit {
+			q.stats.Dropped += int64(n)
+			return nil
+		}
+	}
+
+	q.items = append(q.items, item)
+	q.stats.Queued += n
+	if q.stats.Queued > q.stats.Peak {
+		q.stats.Peak = q.stats.Queued
+	}
+	q.ready.Signal()
+	return nil
+}
+
+// pop removes and returns all queued items, waiting until there
+// are some. It returns false once the queue is closed and empty or
+// has failed.
+func (q *outputQueue) pop() ([]outputItem, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.items) == 0 && !q.closed && !q.failed {
+		q.ready.Wait()
+	}
+	if q.failed || len(q.items) == 0 {
+		return nil, false
+	}
+
+	items := q.items
+	q.items = nil
+	for _, it := range items {
+		q.stats.Queued -= len(it.text)
+		q.stats.Written += int64(len(it.text))
+	}
+	return items, true
+}
+
+// close stops the queue from accepting output. Items already queued
+// are still written.
+func (q *outputQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.ready.Broadcast()
+}
+
+// fail stops the queue after the connection failed, discarding any
+// queued output.
+func (q *outputQueue) fail() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.failed = true
+	q.items = nil
+	q.stats.Queued = 0
+	q.ready.Broadcast()
+}
+
+// setLimit sets the queue's size limit and overflow policy.
+func (q *outputQueue) setLimit(limit int, policy overflowPolicy) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.limit = limit
+	q.policy = policy
+}
+
+// getStats returns the queue's metrics.
+func (q *outputQueue) getStats() outputStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.stats
+}
+
+// queueText queues text to be written to the connection.
+func (c *conn) queueText(s string) {
+	c.queue(outputItem{text: s})
+}
+
+// queueControl queues a function to be called by the connection's
+// writer once the output queued before it has been written.
+func (c *conn) queueControl(fn func()) {
+	c.queue(outputItem{ctl: fn})
+}
+
+// queue adds an item to the connection's output queue, starting the
+// writer if necessary.
+func (c *conn) queue(item outputItem) {
+	c.writeOnce.Do(func() {
+		go c.writeLoop()
+	})
+
+	if err := c.out.push(item); err != nil {
+		log.Printf("Connection dropped: %v\n", err)
+
+		// The writer may be stuck, so close the underlying
+		// connection without waiting for it.
+		go c.closer.Close()
+	}
+}
+
+// writeLoop writes queued output to the connection until the queue
+// closes or the connection fails. It then closes the connection and
+// signals that it has finished.
+func (c *conn) writeLoop() {
+	defer close(c.finished)
+	defer c.closer.Close()
+	for {
+		items, ok := c.out.pop()
+		if !ok {
+			return
+		}
+		for _, it := range items {
+			if it.ctl != nil {
+				c.output.Flush()
+				it.ctl()
+			} else {
+				c.output.WriteString(it.text)
+			}
+		}
+		if err := c.output.Flush(); err != nil {
+			c.out.fail()
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package unimud
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queuedText returns the text of the queued items, with control
+// items shown as "*".
+func queuedText(items []outputItem) []string {
+	var text []string
+	for _, it := range items {
+		if it.ctl != nil {
+			text = append(text, "*")
+		} else {
+			text = append(text, it.text)
+		}
+	}
+	return text
+}
+
+func TestOutputQueueDrop(t *testing.T) {
+	q := newOutputQueue()
+	q.setLimit(10, overflowDrop)
+
+	// The oldest text is dropped to make room, but control items
+	// are kept.
+	for _, it := range []outputItem{{text: "aaaa"}, {ctl: func() {}}, {text: "bbbb"}, {text: "cccc"}} {
+		if err := q.push(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := q.getStats(); s.Queued != 8 || s.Peak != 8 || s.Dropped != 4 || s.Overflows != 1 {
+		t.Errorf("stats %+v after the first overflow", s)
+	}
+
+	// Text larger than the queue is dropped along with everything
+	// queued before it.
+	q.push(outputItem{text: strings.Repeat("x", 11)})
+	q.push(outputItem{text: "dd"})
+	items, ok := q.pop()
+	if got := strings.Join(queuedText(items), ","); !ok || got != "*,dd" {
+		t.Errorf("pop returned %q, %v, want \"*,dd\"", got, ok)
+	}
+	want := outputStats{Queued: 0, Peak: 8, Written: 2, Dropped: 23, Overflows: 2}
+	if s := q.getStats(); s != want {
+		t.Errorf("stats %+v, want %+v", s, want)
+	}
+}
+
+func TestOutputQueueDisconnect(t *testing.T) {
+	q := newOutputQueue()
+	q.setLimit(8, overflowDisconnect)
+	q.push(outputItem{text: "aaaa"})
+	q.push(outputItem{text: "bbbb"})
+	if err := q.push(outputItem{text: "c"}); err != errOutputOverflow {
+		t.Fatalf("push to a full queue returned %v", err)
+	}
+
+	// Nothing more is queued or written.
+	if err := q.push(outputItem{text: "d"}); err != nil {
+		t.Errorf("push after an overflow returned %v", err)
+	}
+	if items, ok := q.pop(); ok {
+		t.Errorf("pop after an overflow returned %q", queuedText(items))
+	}
+	if s := q.getStats(); s.Queued != 0 || s.Overflows != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestOutputQueueClose(t *testing.T) {
+	q := newOutputQueue()
+	q.push(outputItem{text: "a"})
+	q.close()
+	q.push(outputItem{text: "b"})
+
+	// Text queued before the queue closed is still written.
+	items, ok := q.pop()
+	if got := strings.Join(queuedText(items), ","); !ok || got != "a" {
+		t.Errorf("pop returned %q, %v, want \"a\"", got, ok)
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("pop succeeded on a closed, empty queue")
+	}
+}
+
+func TestStalledClient(t *testing.T) {
+	_, addr := newTestGame(t, func(cfg *Config) {
+		cfg.OutputQueueSize = 4096
+	})
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+	b.nc.(*net.TCPConn).SetReadBuffer(1024)
+
+	// Bobby stops reading. Once their queue overflows they are
+	// disconnected, while alice carries on playing.
+	big := strings.Repeat("x", 1000)
+	deadline := time.Now().Add(4 * testTimeout)
+	var text strings.Builder
+	for !strings.Contains(text.String(), "bobby's link has gone dead.") {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the stalled client to be disconnected")
+		}
+		a.send("yell " + big)
+		text.Reset()
+		text.WriteString(a.expect("> "))
+	}
+}
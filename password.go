@@ -0,0 +1,128 @@
+package unimud
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Password hashing parameters. Changing the iteration count only
+// affects newly hashed passwords, since the count is stored with
+// each hash.
+const (
+	pwHashScheme = "pbkdf2-sha256"
+	pwIterations = 100000
+	pwSaltLen    = 16
+	pwKeyLen     = 32
+)
+
+var errBadPasswordHash = errors.New("password: malformed hash")
+
+// hashPassword returns a salted PBKDF2 hash of the password `pw`,
+// encoded as "scheme$iterations$salt$key".
+func hashPassword(pw string) (string, error) {
+	salt := make([]byte, pwSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2SHA256([]byte(pw), salt, pwIterations, pwKeyLen)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", pwHashScheme, pwIterations,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword compares the password `pw` against the stored
+// password `stored`. It returns true if they match. The `legacy`
+// result is true if `stored` is an old plaintext password that
+// should be replaced with a hash. An empty stored password matches
+// nothing.
+func checkPassword(stored, pw string) (match, legacy bool) {
+	// A missing password never matches, even an empty one.
+	if stored == "" {
+		return false, false
+	}
+
+	if !isPasswordHash(stored) {
+		match = subtle.ConstantTimeCompare([]byte(stored), []byte(pw)) == 1
+		return match, true
+	}
+
+	iter, salt, key, err := parsePasswordHash(stored)
+	if err != nil {
+		return false, false
+	}
+
+	k := pbkdf2SHA256([]byte(pw), salt, iter, len(key))
+	return subtle.ConstantTimeCompare(k, key) == 1, false
+}
+
+// isPasswordHash returns true if the stored password `s` is a hash
+// rather than legacy plaintext.
+func isPasswordHash(s string) bool {
+	return strings.HasPrefix(s, pwHashScheme+"$")
+}
+
+// parsePasswordHash splits an encoded password hash into its
+// iteration count, salt and key.
+func parsePasswordHash(s string) (iter int, salt, key []byte, err error) {
+	fields := strings.Split(s, "$")
+	if len(fields) != 4 || fields[0] != pwHashScheme {
+		return 0, nil, nil, errBadPasswordHash
+	}
+
+	iter, err = strconv.Atoi(fields[1])
+	if err != nil || iter < 1 {
+		return 0, nil, nil, errBadPasswordHash
+	}
+
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(fields[2]); err != nil {
+		return 0, nil, nil, errBadPasswordHash
+	}
+	if key, err = enc.DecodeString(fields[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, errBadPasswordHash
+	}
+	return iter, salt, key, nil
+}
+
+// pbkdf2SHA256 derives a key of length `keyLen` from the password
+// using PBKDF2 (RFC 8018) with HMAC-SHA256 as the pseudorandom
+// function.
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		// U1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		// Un = PRF(password, Un-1), xored into the block.
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
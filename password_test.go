@@ -0,0 +1,102 @@
+package unimud
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// Test vectors from RFC 7914 section 11 and the widely used
+	// PBKDF2-HMAC-SHA256 vectors.
+	tests := []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"password", "salt", 1, 32,
+			"120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32,
+			"ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32,
+			"c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, 64,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+				"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iter, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d, %d) = %s, want %s",
+				tt.password, tt.salt, tt.iter, tt.keyLen, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPasswordHash(hash) {
+		t.Fatalf("hashPassword returned %q, which isn't a hash", hash)
+	}
+
+	tests := []struct {
+		name          string
+		stored, pw    string
+		match, legacy bool
+	}{
+		{"hash match", hash, "secret", true, false},
+		{"hash mismatch", hash, "Secret", false, false},
+		{"hash empty", hash, "", false, false},
+		{"legacy match", "secret", "secret", true, true},
+		{"legacy mismatch", "secret", "secrets", false, true},
+		{"missing", "", "", false, false},
+		{"missing with password", "", "secret", false, false},
+		{"malformed hash", pwHashScheme + "$x$y$z", "secret", false, false},
+		{"truncated hash", pwHashScheme + "$1000$c2FsdA", "secret", false, false},
+	}
+	for _, tt := range tests {
+		match, legacy := checkPassword(tt.stored, tt.pw)
+		if match != tt.match || legacy != tt.legacy {
+			t.Errorf("%s: checkPassword = %v, %v, want %v, %v",
+				tt.name, match, legacy, tt.match, tt.legacy)
+		}
+	}
+}
+
+func TestHashPasswordSalted(t *testing.T) {
+	a, _ := hashPassword("secret")
+	b, _ := hashPassword("secret")
+	if a == b {
+		t.Errorf("two hashes of the same password are identical: %q", a)
+	}
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	tests := []struct {
+		s    string
+		iter int
+		ok   bool
+	}{
+		{pwHashScheme + "$1000$c2FsdA$a2V5", 1000, true},
+		{pwHashScheme + "$0$c2FsdA$a2V5", 0, false},
+		{pwHashScheme + "$-5$c2FsdA$a2V5", 0, false},
+		{pwHashScheme + "$1000$c2FsdA$", 0, false},
+		{pwHashScheme + "$1000$!!$a2V5", 0, false},
+		{pwHashScheme + "$1000$c2FsdA", 0, false},
+		{"md5$1000$c2FsdA$a2V5", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		iter, salt, key, err := parsePasswordHash(tt.s)
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("parsePasswordHash(%q): %v", tt.s, err)
+		case tt.ok && (iter != tt.iter || string(salt) != "salt" || string(key) != "key"):
+			t.Errorf("parsePasswordHash(%q) = %d, %q, %q", tt.s, iter, salt, key)
+		case !tt.ok && err != errBadPasswordHash:
+			t.Errorf("parsePasswordHash(%q): got error %v, want %v", tt.s, err, errBadPasswordHash)
+		}
+	}
+}
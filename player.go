@@ -1,24 +1,33 @@
 package unimud
 
 import (
-	"encoding/gob"
 	"errors"
-	"os"
-	"path"
+	"log"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/beevik/prefixtree"
 )
 
 // A player represents a user playing the unimud Game instance.
 type player struct {
-	*conn                             // the embedded connection used for player I/O
-	game       *Game                  // the game this player is associated with
-	resumeChan chan bool              // used by game to wake up this player
-	login      string                 // the player's login id
-	properties map[string]interface{} // all known player properties
-	entered    bool                   // tracks whether the player has entered the game
-	room       *room                  // the room the player is currently in
+	*conn                                // the embedded connection used for player I/O
+	game          *Game                  // the game this player is associated with
+	state         playerState            // handles the player's next line of input
+	login         string                 // the player's login id
+	properties    map[string]interface{} // all known player properties
+	entered       bool                   // tracks whether the player has entered the game
+	room          *room                  // the room the player is currently in
+	dirty         bool                   // true if persistent properties changed since the last save
+	authenticated bool                   // true if the connection already authenticated the login id
+	linkDead      bool                   // true while the player's connection is lost
+	gone          bool                   // true once the player has left the game
+	more          []string               // lines of paged output not yet shown
+	pageHeight    int                    // number of lines shown per screen of paged output
+	timers        timerSet               // timers stopped when the player leaves the game
+	idleTimer     *timer                 // fires when the player has been idle too long
+	linkDeadTimer *timer                 // fires when a link-dead player's grace period ends
 }
 
 // Create a new player associated with the Game g.
@@ -26,226 +35,426 @@ func newPlayer(g *Game, c *conn) *player {
 	return &player{
 		conn:       c,
 		game:       g,
-		resumeChan: make(chan bool),
-		properties: make(map[string]interface{}),
+		properties: defaultProperties(),
+		timers:     make(timerSet),
 	}
 }
 
-// playerState is a function type that operates on a player
-// and returns the next state the player should enter.
-type playerState func(p *player) playerState
+// A playerState handles a line of input from the player and returns
+// the state that handles the next line. A state prints the prompt
+// for the next state before returning it. The player leaves the
+// game when the state becomes nil.
+type playerState func(p *player, line string) playerState
 
-// run launches the player's state machine.
-func (p *player) run() {
-
-	// Start out by requesting a resumption of control
-	// from the game's Run goroutine. We must always do this
-	// before modifying shared game state.
-	p.resume()
-
-	// When the player's goroutine is no longer running,
-	// yield control back to the game's Run goroutine.
-	defer p.yield()
-
-	p.enterGame()
-
-	// Run the player's state machine until state becomes nil.
-	state := (*player).stateLogin
-	for state != nil {
-		state = state(p)
+// start begins the player's session on a new connection.
+func (p *player) start() {
+	cfg := &p.game.config
+	p.setOutputLimit(cfg.OutputQueueSize, overflowPolicyNames[cfg.OutputOverflow])
+	p.game.playerAdd(p)
+	p.resetIdle()
+
+	// Connections that authenticate the player themselves (such
+	// as SSH) skip the login prompt.
+	if p.authenticated {
+		p.setState(p.loginAuthenticated())
+	} else {
+		p.setState(p.promptLogin())
 	}
+}
 
-	p.leaveGame()
+// receive handles a line of input from the player's connection.
+func (p *player) receive(line string) {
+	p.inputReceived()
+	p.resetIdle()
+	p.setState(p.state(p, p.decodeInput(line)))
 }
 
-func (p *player) enterGame() {
-	p.game.playerAdd(p)
+// setState makes `s` the player's state. A nil state ends the
+// player's session.
+func (p *player) setState(s playerState) {
+	if p.gone {
+		return
+	}
+	p.state = s
+	if s == nil {
+		p.leaveGame()
+	}
 }
 
-func (p *player) leaveGame() {
-	p.Close()
+// leaveGame disconnects the player and removes it from the game.
+// It returns an error if the player couldn't be saved.
+func (p *player) leaveGame() error {
+	if p.gone {
+		return nil
+	}
+	p.gone = true
+	p.timers.stopAll()
+
+	// A player whose connection was handed to another player has
+	// none to close.
+	if p.conn != nil {
+		p.game.connRemove(p.conn)
+		p.Close()
+		if written, sent, _ := p.compression(); sent < written {
+			log.Printf("Player %s: compressed %d bytes of output to %d (%.1f%%)\n",
+				p.login, written, sent, 100*float64(sent)/float64(written))
+		}
+		if qs := p.queueStats(); qs.Overflows > 0 {
+			log.Printf("Player %s: output queue overflowed %d times, dropping %d bytes (peak %d queued)\n",
+				p.login, qs.Overflows, qs.Dropped, qs.Peak)
+		}
+	}
 
+	var err error
 	if p.entered {
-		p.save()
+		if err = p.save(); err != nil {
+			log.Printf("Player %s failed to save: %v\n", p.login, err)
+		}
 		p.room.playerLeave(p)
 		p.game.playerLeave(p)
 		p.entered = false
 	}
 
 	p.game.playerRemove(p)
+	return err
 }
 
-// GetLine reads a CR-terminated line of text from the
-// player's input reader. It returns an error if the read
-// fails.
-func (p *player) GetLine() (line string, err error) {
-	// While reading input from the player, yield control
-	// to the game's Run goroutine. There's nothing the
-	// player can do to update the global game state while
-	// input is being received, so this is an ideal time
-	// to yield.
-	p.yield()
+// errQuit is returned by the quit command to end the player's
+// session.
+var errQuit = errors.New("player: disconnecting")
 
-	// Request a resumption of control from the game's Run
-	// goroutine once the player hits the enter key (or
-	// a disconnect occurs).
-	defer p.resume()
+// prompt prints `s` and returns the state `next`, which handles the
+// player's answer.
+func (p *player) prompt(s string, next playerState) playerState {
+	p.Print(s)
+	return next
+}
 
-	// Read a single line of input (up to the CR).
-	p.Flush()
-	if p.conn.input.Scan() {
-		line = p.conn.input.Text()
-		return line, nil
+// promptPassword prints `s` and turns off echo while the player
+// answers. It returns a state that passes the answer to `next`.
+func (p *player) promptPassword(s string, next playerState) playerState {
+	p.Print(s)
+	p.SetEcho(false)
+	return func(p *player, line string) playerState {
+		p.SetEcho(true)
+
+		// The player's carriage return wasn't echoed either.
+		p.Println()
+		return next(p, line)
 	}
+}
+
+// stateWaiting ignores input while the player waits for something,
+// such as a password check, to finish.
+func (p *player) stateWaiting(line string) playerState {
+	return (*player).stateWaiting
+}
 
-	// Something bad happened (probably a disconnect).
-	err = p.conn.input.Err()
-	if err == nil {
-		err = errors.New("player: disconnected")
+// resetIdle restarts the player's idle timer. The player is warned
+// shortly before the idle timeout and then disconnected.
+func (p *player) resetIdle() {
+	p.idleTimer.Stop()
+	idle := time.Duration(p.game.config.IdleTimeout)
+	warning := time.Duration(p.game.config.IdleWarning)
+	if idle <= 0 {
+		return
 	}
 
-	return "", err
+	p.idleTimer = p.after(idle-warning, func() {
+		if warning <= 0 {
+			p.idleExpired()
+			return
+		}
+		p.Printf("\n{Y}You will be disconnected in %v unless you enter something.{x}\n", warning)
+		p.idleTimer = p.after(warning, p.idleExpired)
+	})
 }
 
-// yield control of the Game's state back to the game's Run
-// goroutine.
-func (p *player) yield() {
-	p.game.yieldChan <- true
+// idleExpired disconnects a player who has been idle too long.
+func (p *player) idleExpired() {
+	p.Println("\nYou have been idle too long. Goodbye!")
+	p.setState(nil)
 }
 
-// resume control of the Game's state by requesting it from
-// the Game's Run goroutine.
-func (p *player) resume() {
-	// On the game's "resume request" channel, send the channel
-	// on which to report resumption.
-	p.game.resumeReqChan <- p.resumeChan
+// Page outputs the text `s` one screen at a time. The player is
+// prompted before each new screen and may enter 'q' to skip the
+// rest of the text. Paging starts once the current command
+// finishes.
+func (p *player) Page(s string) {
+	lines, height := p.pageLines(s)
 
-	// Wait until the game's Run goroutine signals resumption
-	// on our resume channel.
-	<-p.resumeChan
+	// Don't page a trailing empty line.
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	p.more = lines
+	p.pageHeight = height
+	p.showPage()
 }
 
-// stateLogin handles player I/O when the player is in
-// the login state.
-func (p *player) stateLogin() playerState {
-	p.Print("login: ")
-	login, err := p.GetLine()
-	if err != nil {
-		return nil
+// showPage outputs the next screen of paged output.
+func (p *player) showPage() {
+	// Leave room on the screen for the prompt.
+	n := len(p.more)
+	if h := p.pageHeight; h > 1 && n > h-1 {
+		n = h - 1
 	}
+	p.Print(strings.Join(p.more[:n], ""))
+	p.more = p.more[n:]
+}
+
+// statePaging handles the player's answer to the pager's prompt.
+func (p *player) statePaging(line string) playerState {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), "q") {
+		p.more = nil
+	} else {
+		p.showPage()
+	}
+	return p.promptCommand()
+}
 
+// promptLogin asks for the player's login id.
+func (p *player) promptLogin() playerState {
+	return p.prompt("login: ", (*player).stateLogin)
+}
+
+// stateLogin handles the login id entered by the player.
+func (p *player) stateLogin(login string) playerState {
 	// Check for an invalid login id
-	switch {
-	case len(login) == 0:
-		return (*player).stateLogin
-	case len(login) < 4:
+	cfg := &p.game.config
+	switch n := utf8.RuneCountInString(login); {
+	case n == 0:
+		return p.promptLogin()
+	case n < cfg.LoginMinLen:
 		p.Println("login id is too short.")
-		return (*player).stateLogin
-	case len(login) > 32:
+		return p.promptLogin()
+	case n > cfg.LoginMaxLen:
 		p.Println("login id is too long.")
-		return (*player).stateLogin
+		return p.promptLogin()
 	case !validateLogin(login):
 		p.Println("login id contains invalid characters.")
-		return (*player).stateLogin
+		return p.promptLogin()
 	}
 
-	// Attempt to load the player from disk (or db).
+	// Create a new player if the login id isn't in the player
+	// store.
 	p.login = login
-	if err := p.load(); err != nil {
-		return (*player).stateCreateNew
+	exists, err := p.game.PlayerStore.Exists(login)
+	if err != nil {
+		log.Printf("Player %s lookup failed: %v\n", login, err)
+		p.Println("error: player couldn't be loaded.")
+		return p.promptLogin()
+	}
+	if !exists {
+		return p.promptPassword("enter password: ", (*player).stateCreateNew)
 	}
 
-	// Request the password
-	p.Print("password: ")
-	pw, err := p.GetLine()
-	if err != nil {
-		return nil
+	// Attempt to load the player from the player store.
+	if err := p.load(); err != nil {
+		log.Printf("Player %s failed to load: %v\n", login, err)
+		p.Println("error: player couldn't be loaded.")
+		return p.promptLogin()
 	}
 
-	// Check the password
-	if pw != p.properties["pw"].(string) {
+	return p.promptPassword("password: ", (*player).statePassword)
+}
+
+// statePassword checks the password entered by an existing player.
+func (p *player) statePassword(pw string) playerState {
+	// Hashing is deliberately slow, so check the password in the
+	// background.
+	stored := p.propString("pw")
+	var match, legacy bool
+	p.game.async(func() {
+		match, legacy = checkPassword(stored, pw)
+	}, func() {
+		if !p.gone {
+			p.setState(p.passwordChecked(pw, match, legacy))
+		}
+	})
+	return (*player).stateWaiting
+}
+
+// passwordChecked continues logging in once the player's password
+// has been checked.
+func (p *player) passwordChecked(pw string, match, legacy bool) playerState {
+	if !match {
 		p.Println("incorrect password.")
-		return (*player).stateLogin
+		return p.promptLogin()
+	}
+
+	// Replace a plaintext password from an old player file with
+	// a hash.
+	if legacy {
+		p.game.upgradePassword(p.login, pw)
 	}
 
-	// TODO: Check for player already logged in
+	// Offer to take over the session of a player who is already
+	// logged in.
 	if p.game.playerMap[p.login] != nil {
-		p.Println("A player with that login id is already logged in.")
-		return (*player).stateLogin
+		return p.promptTakeover()
 	}
 
-	return (*player).stateEnteringGame
+	return p.enterWorld()
 }
 
-// stateCreateNew handles the creation of new player
-// account data.
-func (p *player) stateCreateNew() playerState {
-	p.Print("enter password: ")
-	pw, err := p.GetLine()
-	if err != nil {
+// loginAuthenticated loads a player whose login id was already
+// authenticated by the connection.
+func (p *player) loginAuthenticated() playerState {
+	if err := p.load(); err != nil {
+		log.Printf("Player %s failed to load: %v\n", p.login, err)
+		p.Println("error: player couldn't be loaded.")
 		return nil
 	}
 
+	if p.game.playerMap[p.login] != nil {
+		return p.promptTakeover()
+	}
+
+	return p.enterWorld()
+}
+
+// promptTakeover offers to move a player who is already logged in
+// onto the new connection.
+func (p *player) promptTakeover() playerState {
+	return p.prompt("That character is already playing. Take over the session? (y/n) ", (*player).stateTakeover)
+}
+
+// stateTakeover handles the answer to the takeover prompt. The old
+// connection is kicked, and the player carries on where they were.
+func (p *player) stateTakeover(line string) playerState {
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), "y") {
+		if p.authenticated {
+			return nil
+		}
+		return p.promptLogin()
+	}
+
+	// The player may have left while we were waiting.
+	old := p.game.playerMap[p.login]
+	if old == nil {
+		if err := p.load(); err != nil {
+			log.Printf("Player %s failed to load: %v\n", p.login, err)
+			p.Println("error: player couldn't be loaded.")
+			return nil
+		}
+		return p.enterWorld()
+	}
+
+	log.Printf("Player %s is taking over their session.\n", p.login)
+	if !old.linkDead {
+		old.Println("\n{R}Your session has been taken over by another connection.{x}")
+		p.game.connRemove(old.conn)
+		old.Close()
+	}
+
+	// The connection now belongs to the old player.
+	c := p.conn
+	p.game.connRemove(c)
+	p.conn = nil
+	old.reconnect(c)
+	return nil
+}
+
+// stateCreateNew handles the password entered for a new player.
+func (p *player) stateCreateNew(pw string) playerState {
 	// Validate password
+	cfg := &p.game.config
 	switch {
-	case len(pw) < 4:
+	case len(pw) < cfg.PasswordMinLen:
 		p.Println("password too short.")
-		return (*player).stateLogin
-	case len(pw) > 32:
+		return p.promptLogin()
+	case len(pw) > cfg.PasswordMaxLen:
 		p.Println("password too long.")
-		return (*player).stateLogin
+		return p.promptLogin()
 	}
 
 	// Confirm password
-	p.Print("re-enter password: ")
-	rpw, err := p.GetLine()
-	if err != nil {
-		return nil
-	}
+	return p.promptPassword("re-enter password: ", func(p *player, rpw string) playerState {
+		return p.stateConfirmPassword(pw, rpw)
+	})
+}
+
+// stateConfirmPassword creates a new player once the password has
+// been entered a second time.
+func (p *player) stateConfirmPassword(pw, rpw string) playerState {
 	if pw != rpw {
 		p.Println("passwords don't match.")
-		return (*player).stateLogin
+		return p.promptLogin()
 	}
 
 	// Initialize all new player player properties
-	p.properties["pw"] = pw
-	p.properties["room"] = 0
+	p.properties = defaultProperties()
+	p.setPropInt("room", p.game.config.StartRoom)
+	p.setPassword(pw, func(p *player, err error) {
+		if err != nil {
+			p.Println("error: password couldn't be set.")
+			p.setState(nil)
+			return
+		}
 
-	// Save the player
-	if err := p.save(); err != nil {
-		p.Println("error: player couldn't be saved.")
-		return nil
-	}
+		// Another connection may have created a player with the
+		// same login id while the password was being hashed.
+		exists, err := p.game.PlayerStore.Exists(p.login)
+		if err != nil {
+			log.Printf("Player %s lookup failed: %v\n", p.login, err)
+			p.Println("error: player couldn't be created.")
+			p.setState(p.promptLogin())
+			return
+		}
+		if exists || p.game.playerMap[p.login] != nil {
+			p.Println("login id is already taken.")
+			p.setState(p.promptLogin())
+			return
+		}
 
-	return (*player).stateEnteringGame
+		// Save the player
+		if err := p.save(); err != nil {
+			p.Println("error: player couldn't be saved.")
+			p.setState(nil)
+			return
+		}
+		p.setState(p.enterWorld())
+	})
+	return (*player).stateWaiting
 }
 
-// stateEnteringGame is a transitional state that is
-// entered just before the player starts playing the game.
-func (p *player) stateEnteringGame() playerState {
-	// Load the player's starting room
-	roomID := p.properties["room"].(int)
+// enterWorld puts the player into the game world, just before the
+// player starts playing.
+func (p *player) enterWorld() playerState {
+	// Load the player's starting room. If it's gone, fall back to
+	// the game's start room.
+	roomID := p.propInt("room")
 	r, err := p.game.roomGet(roomID)
+	if err != nil && roomID != p.game.config.StartRoom {
+		log.Printf("Room %d failed to load: %v\n", roomID, err)
+		r, err = p.game.roomGet(p.game.config.StartRoom)
+	}
 	if err != nil {
-		return (*player).stateLogin
+		log.Printf("Start room failed to load: %v\n", err)
+		return p.promptLogin()
 	}
 
 	// Enter the game world
+	p.applySettings()
 	p.game.playerEnter(p)
 	r.playerEnter(p)
 	r.display(p)
-	return (*player).statePlaying
+	return p.promptCommand()
 }
 
-// statePlaying is the state a player enters while playing
-// the game itself.
-func (p *player) statePlaying() playerState {
-	p.Print("> ")
-	line, err := p.GetLine()
-	if err != nil {
-		return nil
+// promptCommand asks the player for the next command. If a command
+// left paged output, the pager's prompt is shown instead.
+func (p *player) promptCommand() playerState {
+	if len(p.more) > 0 {
+		return p.prompt("{W}[more]{x} (Enter/q) ", (*player).statePaging)
 	}
+	return p.prompt("> ", (*player).statePlaying)
+}
 
+// statePlaying is the state a player enters while playing
+// the game itself.
+func (p *player) statePlaying(line string) playerState {
 	// Parse the command and associated arguments
 	var cmd, arg string
 	segments := strings.SplitN(line, " ", 2)
@@ -258,7 +467,7 @@ func (p *player) statePlaying() playerState {
 
 	// Empty command is a no-op
 	if cmd == "" {
-		return (*player).statePlaying
+		return p.promptCommand()
 	}
 
 	// Find the command in the prefix tree
@@ -266,10 +475,10 @@ func (p *player) statePlaying() playerState {
 	switch {
 	case err == prefixtree.ErrPrefixNotFound:
 		p.Println("command not found.")
-		return (*player).statePlaying
+		return p.promptCommand()
 	case err == prefixtree.ErrPrefixAmbiguous:
 		p.Println("command ambiguous.")
-		return (*player).statePlaying
+		return p.promptCommand()
 	case h == nil:
 		return nil
 	}
@@ -279,11 +488,133 @@ func (p *player) statePlaying() playerState {
 		return nil
 	}
 
-	return (*player).statePlaying
+	return p.promptCommand()
+}
+
+// connectionLost is called when the player's connection fails. A
+// player who is playing stays in the game for a grace period, in
+// case the connection was dropped by accident, and then leaves. A
+// new connection may take over the player during the grace period.
+func (p *player) connectionLost() {
+	grace := time.Duration(p.game.config.LinkDeadTimeout)
+	if !p.entered || grace <= 0 {
+		p.setState(nil)
+		return
+	}
+
+	p.Close()
+	log.Printf("Player %s lost their link.\n", p.login)
+	p.linkDead = true
+	p.idleTimer.Stop()
+	for _, op := range p.room.players {
+		if op != p {
+			op.Printf("%s's link has gone dead.\n", p.login)
+		}
+	}
+
+	p.linkDeadTimer = p.after(grace, func() {
+		p.setState(nil)
+	})
+}
+
+// reconnect attaches the connection `c` to the player, who carries
+// on playing.
+func (p *player) reconnect(c *conn) {
+	p.conn = c
+	p.game.connAdd(c, p)
+	p.linkDead = false
+	p.linkDeadTimer.Stop()
+	p.resetIdle()
+	p.applySettings()
+
+	log.Printf("Player %s reconnected.\n", p.login)
+	for _, op := range p.room.players {
+		if op != p {
+			op.Printf("%s has reconnected.\n", p.login)
+		}
+	}
+	p.Println("Reconnected.")
+	p.room.display(p)
+	p.sendCharStatus()
+	p.more = nil
+	p.setState(p.promptCommand())
+}
+
+// setPassword stores a hash of the password `pw` in the player's
+// properties. Hashing is deliberately slow, so it happens in the
+// background, and `done` is called once it finishes. If the player
+// leaves in the meantime, `done` isn't called.
+func (p *player) setPassword(pw string, done func(p *player, err error)) {
+	var hash string
+	var err error
+	p.game.async(func() {
+		hash, err = hashPassword(pw)
+	}, func() {
+		if p.gone {
+			return
+		}
+		if err == nil {
+			p.setPropString("pw", hash)
+		}
+		done(p, err)
+	})
+}
+
+// upgradePassword replaces the plaintext password `pw`, left over
+// from an old player file, with a hash. If the player is in the
+// game by the time the hash is ready, the playing copy is changed,
+// so that its next save doesn't restore the plaintext. Otherwise
+// the stored player is changed. Nothing is changed if the password
+// was changed in the meantime. It may be called on any goroutine.
+func (g *Game) upgradePassword(login, pw string) {
+	var hash string
+	var err error
+	g.async(func() {
+		hash, err = hashPassword(pw)
+	}, func() {
+		if err != nil {
+			log.Printf("Player %s password upgrade failed: %v\n", login, err)
+			return
+		}
+
+		if p := g.playerMap[login]; p != nil {
+			if p.propString("pw") == pw {
+				p.setPropString("pw", hash)
+				if err := p.save(); err != nil {
+					log.Printf("Player %s failed to save: %v\n", login, err)
+				}
+			}
+			return
+		}
+
+		props, err := g.PlayerStore.Load(login)
+		if err != nil || props["pw"] != pw {
+			return
+		}
+		props["pw"] = hash
+		if err := g.PlayerStore.Save(login, props); err != nil {
+			log.Printf("Player %s failed to save: %v\n", login, err)
+		}
+	})
+}
+
+// applySettings configures the player's connection according to
+// the player's editable properties.
+func (p *player) applySettings() {
+	p.setCharsetPref(p.propString("charset"))
+	p.setColorPref(p.propString("color"))
+	p.setWidthPref(p.propInt("width"))
 }
 
 // validateLogin checks a login id string for invalid
-// characters and returns true if validation succeeds.
+// characters and returns true if validation succeeds. Login ids
+// are limited to ASCII letters on purpose, even though other text
+// may be in any script. Letters from other scripts would need
+// Unicode normalization and confusable detection, which the
+// standard library doesn't provide, to stop one player
+// impersonating another with look-alike names. ASCII login ids are
+// also safe to use as file names and SSH user names, and can be
+// typed in every character set the game supports.
 func validateLogin(login string) bool {
 	for _, c := range login {
 		switch {
@@ -306,51 +637,23 @@ func stripLeadingWhitespace(s string) string {
 	return ""
 }
 
-// save stores the player's data to disk and returns an
-// error if the save fails.
+// save stores the player's data in the game's player store and
+// returns an error if the save fails.
 func (p *player) save() error {
-	filename := path.Join("players", p.login+".dat")
-	f, err := os.Create(filename)
-	if err != nil {
+	if err := p.game.PlayerStore.Save(p.login, p.persistentProperties()); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	enc := gob.NewEncoder(f)
-
-	if err := enc.Encode(p.login); err != nil {
-		return err
-	}
-	if err := enc.Encode(p.properties); err != nil {
-		return err
-	}
-
+	p.dirty = false
 	return nil
 }
 
-// load reads the player's data from disk and returns
-// an error if the load fails.
+// load reads the player's data from the game's player store and
+// returns an error if the load fails.
 func (p *player) load() error {
-	filename := path.Join("players", p.login+".dat")
-	f, err := os.Open(filename)
+	props, err := p.game.PlayerStore.Load(p.login)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	dec := gob.NewDecoder(f)
-
-	var login string
-	if err := dec.Decode(&login); err != nil {
-		return err
-	}
-	if login != p.login {
-		return errors.New("player: login id mismatch")
-	}
-
-	if err := dec.Decode(&p.properties); err != nil {
-		return err
-	}
-
-	return nil
+	p.properties, err = validateProperties(p.login, props)
+	return err
 }
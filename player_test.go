@@ -0,0 +1,315 @@
+package unimud
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storedPassword returns the stored password of player `login`.
+func storedPassword(t *testing.T, g *Game, login string) string {
+	t.Helper()
+	props, err := g.PlayerStore.Load(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, _ := props["pw"].(string)
+	return pw
+}
+
+func TestLegacyPasswordUpgrade(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	g.PlayerStore.Save("alice", map[string]interface{}{"pw": "secret", "room": 0})
+
+	// Logging in replaces the plaintext password with a hash.
+	a := loginTestPlayer(t, addr, "alice")
+	waitFor(t, "the password to be hashed", func() bool {
+		return isPasswordHash(storedPassword(t, g, "alice"))
+	})
+	onGameLoop(g, func() {
+		if pw := g.playerMap["alice"].propString("pw"); !isPasswordHash(pw) {
+			t.Errorf("playing copy of the password is %q, want a hash", pw)
+		}
+	})
+
+	// Put the plaintext password back in both copies of the
+	// player, then log in again. The password is upgraded even
+	// though the new connection stops at the takeover prompt.
+	onGameLoop(g, func() {
+		p := g.playerMap["alice"]
+		p.setPropString("pw", "secret")
+		p.save()
+	})
+	b := dialTestGame(t, addr)
+	b.expect("login: ")
+	b.send("alice")
+	b.expect("password: ")
+	b.send("secret")
+	b.expect("(y/n) ")
+	waitFor(t, "the password to be hashed", func() bool {
+		return isPasswordHash(storedPassword(t, g, "alice"))
+	})
+
+	// The hash was stored on the playing copy, so saving it
+	// doesn't restore the plaintext.
+	a.send("save")
+	a.expect("> ")
+	if pw := storedPassword(t, g, "alice"); !isPasswordHash(pw) {
+		t.Errorf("stored password is %q after saving, want a hash", pw)
+	}
+	if match, _ := checkPassword(storedPassword(t, g, "alice"), "secret"); !match {
+		t.Error("upgraded password doesn't match")
+	}
+}
+
+func TestValidateLogin(t *testing.T) {
+	tests := []struct {
+		login string
+		ok    bool
+	}{
+		{"alice", true},
+		{"Alice", true},
+		{"alice2", false},
+		{"al ice", false},
+		{"al_ice", false},
+		{"../alice", false},
+		{"jos\u00e9", false},
+		{"\u0430lice", false}, // Cyrillic a
+		{"\uff41lice", false}, // fullwidth a
+	}
+	for _, tt := range tests {
+		if got := validateLogin(tt.login); got != tt.ok {
+			t.Errorf("validateLogin(%q) = %v, want %v", tt.login, got, tt.ok)
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	g, addr := newTestGame(t, func(cfg *Config) {
+		cfg.IdleTimeout = Duration(600 * time.Millisecond)
+		cfg.IdleWarning = Duration(300 * time.Millisecond)
+	})
+	addTestPlayer(t, g, "alice")
+	c := loginTestPlayer(t, addr, "alice")
+	c.expect("You will be disconnected in 300ms unless you enter something.")
+	c.expect("You have been idle too long. Goodbye!")
+	c.closed()
+	waitFor(t, "the player to leave", func() bool {
+		var playing bool
+		onGameLoop(g, func() { playing = g.playerMap["alice"] != nil })
+		return !playing
+	})
+}
+
+func TestIdleTimeoutReload(t *testing.T) {
+	g, addr := newTestGame(t, func(cfg *Config) {
+		cfg.IdleTimeout = Duration(time.Hour)
+		cfg.IdleWarning = Duration(time.Minute)
+	})
+	c := loginTestPlayer(t, addr, "alice")
+
+	// A shorter idle timeout applies to players already playing.
+	cfg := g.settings()
+	cfg.IdleTimeout = Duration(600 * time.Millisecond)
+	cfg.IdleWarning = Duration(300 * time.Millisecond)
+	g.Reload(&cfg)
+	c.expect("You will be disconnected in 300ms unless you enter something.")
+	c.expect("You have been idle too long. Goodbye!")
+	c.closed()
+}
+
+func TestLinkDead(t *testing.T) {
+	g, addr := newTestGame(t, func(cfg *Config) {
+		cfg.LinkDeadTimeout = Duration(300 * time.Millisecond)
+	})
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+	a.send("north")
+	a.expect("Attic")
+	b.send("north")
+	b.expect("Attic")
+
+	// The player stays in the game for a while after losing
+	// their connection, and is saved when they leave.
+	a.nc.Close()
+	b.expect("alice's link has gone dead.")
+	b.expect("alice left the game.")
+	if props, err := g.PlayerStore.Load("alice"); err != nil || props["room"] != 1 {
+		t.Errorf("alice saved in room %v, %v, want 1", props["room"], err)
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	clients := []*testClient{dialTestGame(t, addr), dialTestGame(t, addr)}
+	for _, c := range clients {
+		c.expect("login: ")
+		c.send("carol")
+		c.expect("enter password: ")
+		c.send("secret")
+		c.expect("re-enter password: ")
+	}
+
+	// Both connections were offered the new login id, but only
+	// one of them gets it.
+	for _, c := range clients {
+		c.send("secret")
+	}
+	taken := 0
+	for _, c := range clients {
+		var text strings.Builder
+		for !strings.HasSuffix(text.String(), "> ") && !strings.HasSuffix(text.String(), "login: ") {
+			b, err := c.readByte()
+			if err != nil {
+				t.Fatalf("waiting for a prompt: %v; got %q", err, text.String())
+			}
+			text.WriteByte(b)
+		}
+		if strings.Contains(text.String(), "login id is already taken.") {
+			taken++
+		}
+	}
+	if taken != 1 {
+		t.Fatalf("%d connections were refused the login id, want 1", taken)
+	}
+	waitFor(t, "the player to enter the game", func() bool {
+		var playing bool
+		onGameLoop(g, func() { playing = g.playerMap["carol"] != nil })
+		return playing
+	})
+}
+
+func TestTakeover(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	addTestPlayer(t, g, "alice")
+	a := loginTestPlayer(t, addr, "alice")
+	b := loginTestPlayer(t, addr, "bobby")
+
+	// takeover logs in as alice from a new connection.
+	takeover := func(answer string) *testClient {
+		c := dialTestGame(t, addr)
+		c.expect("login: ")
+		c.send("alice")
+		c.expect("password: ")
+		c.send("secret")
+		c.expect("Take over the session? (y/n) ")
+		c.send(answer)
+		return c
+	}
+
+	// Declining leaves the session alone.
+	c := takeover("n")
+	c.expect("login: ")
+	a.send("save")
+	a.expect("Saved.")
+
+	// The old connection is closed and the player carries on
+	// with the new one.
+	c = takeover("y")
+	a.expect("Your session has been taken over by another connection.")
+	a.closed()
+	c.expect("Reconnected.")
+	b.expect("alice has reconnected.")
+	c.send("say back")
+	b.expect("alice says, 'back'.")
+
+	// A link-dead player can be taken over too.
+	c.nc.Close()
+	b.expect("alice's link has gone dead.")
+	c = takeover("y")
+	c.expect("Reconnected.")
+	b.expect("alice has reconnected.")
+	c.send("quit")
+	b.expect("alice left the game.")
+	onGameLoop(g, func() {
+		if g.playerMap["alice"] != nil {
+			t.Error("alice is still in the game")
+		}
+	})
+}
+
+// expectRaw reads the game's output until the text `s` appears, and
+// returns everything read, including telnet commands.
+func (c *testClient) expectRaw(s string) []byte {
+	c.t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(testTimeout))
+	var raw []byte
+	for !bytes.Contains(raw, []byte(s)) {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v; got %q", s, err, raw)
+		}
+		raw = append(raw, b)
+	}
+	return raw
+}
+
+func TestPasswordEcho(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	addTestPlayer(t, g, "alice")
+	willEcho := []byte{telnetIAC, telnetWILL, optEcho}
+	wontEcho := []byte{telnetIAC, telnetWONT, optEcho}
+
+	c := dialTestGame(t, addr)
+	if raw := c.expectRaw("login: "); bytes.Contains(raw, willEcho) {
+		t.Errorf("echo turned off before the login prompt: %q", raw)
+	}
+	c.send("alice")
+
+	// The client stops echoing while the password is typed, and
+	// starts again afterwards.
+	if raw := c.expectRaw(string(willEcho)); !bytes.Contains(raw, []byte("password: ")) {
+		t.Errorf("echo turned off before the password prompt: %q", raw)
+	}
+	c.nc.Write([]byte{telnetIAC, telnetDO, optEcho})
+	c.send("secret")
+	if raw := c.expectRaw("> "); !bytes.Contains(raw, wontEcho) {
+		t.Errorf("echo not turned back on after the password: %q", raw)
+	}
+}
+
+func TestPaging(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	logins := []string{"alice", "bobby", "carol", "david", "erica"}
+	for _, login := range logins {
+		addTestPlayer(t, g, login)
+		loginTestPlayer(t, addr, login)
+	}
+
+	// A screen 3 lines high shows 2 lines of text and the pager's
+	// prompt.
+	c := dialTestGame(t, addr)
+	c.nc.Write([]byte{telnetIAC, telnetWILL, optNAWS,
+		telnetIAC, telnetSB, optNAWS, 0, 80, 0, 3, telnetIAC, telnetSE})
+	c.expect("login: ")
+	c.send("alice")
+	c.expect("password: ")
+	c.send("secret")
+	c.expect("(y/n) ")
+	c.send("y")
+	c.expect("> ")
+
+	c.send("who")
+	if got := c.expect("(Enter/q) "); !strings.HasSuffix(got, "alice\r\nbobby\r\n\x1b[97m[more]\x1b[0m (Enter/q) ") {
+		t.Errorf("first page %q", got)
+	}
+	c.send("")
+	if got := c.expect("(Enter/q) "); got != "carol\r\ndavid\r\n\x1b[97m[more]\x1b[0m (Enter/q) " {
+		t.Errorf("second page %q", got)
+	}
+
+	// Quitting the pager skips the rest of the text.
+	c.send("q")
+	if got := c.expect("> "); strings.Contains(got, "erica") {
+		t.Errorf("text shown after quitting the pager: %q", got)
+	}
+	c.send("who")
+	c.expect("(Enter/q) ")
+	c.send("")
+	c.expect("(Enter/q) ")
+	c.send("")
+	if got := c.expect("> "); !strings.Contains(got, "erica\r\n") {
+		t.Errorf("last page %q", got)
+	}
+}
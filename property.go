@@ -0,0 +1,264 @@
+package unimud
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// A propertyType identifies the type of a player property's value.
+type propertyType int
+
+const (
+	propString propertyType = iota
+	propInt
+	propBool
+)
+
+func (t propertyType) String() string {
+	switch t {
+	case propString:
+		return "string"
+	case propInt:
+		return "int"
+	case propBool:
+		return "bool"
+	}
+	return "unknown"
+}
+
+// A propertyVisibility determines whether the player can see and
+// change a property.
+type propertyVisibility int
+
+const (
+	propHidden   propertyVisibility = iota // internal to the game
+	propVisible                            // shown to the player
+	propEditable                           // shown to and changeable by the player
+)
+
+// A propertyDef declares a player property.
+type propertyDef struct {
+	name       string             // property name
+	typ        propertyType       // type of the property's value
+	def        interface{}        // default value
+	persist    bool               // true if saved with the player
+	visibility propertyVisibility // whether the player can see or change it
+	values     []string           // allowed values of an editable string property (nil for any)
+	required   bool               // true if a saved player must have a valid, non-empty value
+}
+
+// propertyList declares all player properties. A property added
+// here is given its default value when an older player is loaded.
+var propertyList = []propertyDef{
+	{"charset", propString, charsetAuto, true, propEditable, []string{charsetAuto, "utf-8", "iso-8859-1", "us-ascii"}, false},
+	{"color", propString, colorAuto, true, propEditable, []string{colorAuto, "off", "ansi", "256", "truecolor"}, false},
+	{"pw", propString, "", true, propHidden, nil, true},
+	{"replyto", propString, "", true, propHidden, nil, false},
+	{"room", propInt, 0, true, propVisible, nil, false},
+	{"sshkeys", propString, "", true, propHidden, nil, false},
+	{"width", propInt, 0, true, propEditable, nil, false},
+}
+
+var propertyDefs = make(map[string]*propertyDef)
+
+// Build the property schema lookup table.
+func init() {
+	for i := range propertyList {
+		d := &propertyList[i]
+		if !d.typ.check(d.def) {
+			panic(fmt.Sprintf("property %s: default is not a %v", d.name, d.typ))
+		}
+		propertyDefs[d.name] = d
+	}
+}
+
+// check returns true if `v` is a valid value for the type.
+func (t propertyType) check(v interface{}) bool {
+	switch v.(type) {
+	case string:
+		return t == propString
+	case int:
+		return t == propInt
+	case bool:
+		return t == propBool
+	}
+	return false
+}
+
+// convert attempts to convert `v` to a value of the type. This
+// allows integers stored with a different width to be recovered.
+func (t propertyType) convert(v interface{}) (interface{}, bool) {
+	if t.check(v) {
+		return v, true
+	}
+	if t == propInt {
+		switch n := v.(type) {
+		case int8:
+			return int(n), true
+		case int16:
+			return int(n), true
+		case int32:
+			return int(n), true
+		case int64:
+			return int(n), true
+		case uint8:
+			return int(n), true
+		case uint16:
+			return int(n), true
+		case uint32:
+			return int(n), true
+		}
+	}
+	return nil, false
+}
+
+// lookupProperty returns the declaration of the property `name`.
+// Using an undeclared property is a programming error, so it
+// panics.
+func lookupProperty(name string, typ propertyType) *propertyDef {
+	d, ok := propertyDefs[name]
+	if !ok {
+		panic("player: undeclared property " + name)
+	}
+	if d.typ != typ {
+		panic(fmt.Sprintf("player: property %s is a %v, not a %v", name, d.typ, typ))
+	}
+	return d
+}
+
+// defaultProperties returns a property map containing the default
+// value of every declared property.
+func defaultProperties() map[string]interface{} {
+	props := make(map[string]interface{}, len(propertyList))
+	for _, d := range propertyList {
+		props[d.name] = d.def
+	}
+	return props
+}
+
+// validateProperties checks loaded properties against the schema.
+// Missing properties are given their default values, values of the
+// wrong type are converted or replaced with defaults, and unknown
+// properties are dropped. Problems are logged with the player's
+// login id. An error is returned if a required property is missing
+// or invalid, since the player can't safely be used.
+func validateProperties(login string, loaded map[string]interface{}) (map[string]interface{}, error) {
+	props := defaultProperties()
+	for name, v := range loaded {
+		d, ok := propertyDefs[name]
+		switch {
+		case !ok:
+			log.Printf("Player %s: dropping unknown property %s\n", login, name)
+		case !d.persist:
+			// Shouldn't have been saved; use the default.
+		default:
+			if cv, ok := d.typ.convert(v); ok {
+				props[name] = cv
+			} else {
+				log.Printf("Player %s: property %s has type %T, want %v\n", login, name, v, d.typ)
+			}
+		}
+	}
+
+	for _, d := range propertyList {
+		if d.required && props[d.name] == d.def {
+			return nil, fmt.Errorf("player %s: property %s is missing or invalid", login, d.name)
+		}
+	}
+	return props, nil
+}
+
+// parseValue converts the text `s` entered by a player into a value
+// for the property.
+func (d *propertyDef) parseValue(s string) (interface{}, error) {
+	switch d.typ {
+	case propInt:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a number of at least 0", d.name)
+		}
+		return n, nil
+	case propBool:
+		switch strings.ToLower(s) {
+		case "on", "yes", "true":
+			return true, nil
+		case "off", "no", "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%s must be on or off", d.name)
+	}
+	if d.values != nil {
+		for _, v := range d.values {
+			if strings.EqualFold(s, v) {
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be one of: %s", d.name, strings.Join(d.values, ", "))
+	}
+	return s, nil
+}
+
+// persistentProperties returns a copy of the player's properties
+// that should be saved.
+func (p *player) persistentProperties() map[string]interface{} {
+	props := make(map[string]interface{}, len(p.properties))
+	for name, v := range p.properties {
+		if d, ok := propertyDefs[name]; ok && d.persist {
+			props[name] = v
+		}
+	}
+	return props
+}
+
+// propString returns the value of the string property `name`.
+func (p *player) propString(name string) string {
+	d := lookupProperty(name, propString)
+	if v, ok := p.properties[name].(string); ok {
+		return v
+	}
+	return d.def.(string)
+}
+
+// propInt returns the value of the int property `name`.
+func (p *player) propInt(name string) int {
+	d := lookupProperty(name, propInt)
+	if v, ok := p.properties[name].(int); ok {
+		return v
+	}
+	return d.def.(int)
+}
+
+// propBool returns the value of the bool property `name`.
+func (p *player) propBool(name string) bool {
+	d := lookupProperty(name, propBool)
+	if v, ok := p.properties[name].(bool); ok {
+		return v
+	}
+	return d.def.(bool)
+}
+
+// setPropString sets the value of the string property `name`.
+func (p *player) setPropString(name, v string) {
+	p.setProp(lookupProperty(name, propString), v)
+}
+
+// setPropInt sets the value of the int property `name`.
+func (p *player) setPropInt(name string, v int) {
+	p.setProp(lookupProperty(name, propInt), v)
+}
+
+// setPropBool sets the value of the bool property `name`.
+func (p *player) setPropBool(name string, v bool) {
+	p.setProp(lookupProperty(name, propBool), v)
+}
+
+// setProp stores a property value and marks the player as needing
+// a save if a persistent property changed.
+func (p *player) setProp(d *propertyDef, v interface{}) {
+	if d.persist && p.properties[d.name] != v {
+		p.dirty = true
+	}
+	p.properties[d.name] = v
+}
@@ -0,0 +1,95 @@
+package unimud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateProperties(t *testing.T) {
+	// valid returns the default properties with the changes in `m`.
+	valid := func(m map[string]interface{}) map[string]interface{} {
+		props := defaultProperties()
+		props["pw"] = "secret"
+		for k, v := range m {
+			props[k] = v
+		}
+		return props
+	}
+
+	tests := []struct {
+		name   string
+		loaded map[string]interface{}
+		want   map[string]interface{} // nil if an error is expected
+	}{
+		{"complete",
+			map[string]interface{}{"pw": "secret", "room": 3, "color": "ansi"},
+			valid(map[string]interface{}{"room": 3, "color": "ansi"})},
+		{"missing properties get defaults",
+			map[string]interface{}{"pw": "secret"},
+			valid(nil)},
+		{"narrow integer converted",
+			map[string]interface{}{"pw": "secret", "room": int64(7), "width": uint16(80)},
+			valid(map[string]interface{}{"room": 7, "width": 80})},
+		{"wrong type replaced",
+			map[string]interface{}{"pw": "secret", "room": "7", "color": 1},
+			valid(nil)},
+		{"unknown dropped",
+			map[string]interface{}{"pw": "secret", "hitpoints": 10},
+			valid(nil)},
+		{"missing password",
+			map[string]interface{}{"room": 3},
+			nil},
+		{"empty password",
+			map[string]interface{}{"pw": "", "room": 3},
+			nil},
+		{"password of the wrong type",
+			map[string]interface{}{"pw": 1234},
+			nil},
+		{"nothing",
+			nil,
+			nil},
+	}
+	for _, tt := range tests {
+		got, err := validateProperties("alice", tt.loaded)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%s: validateProperties succeeded, want an error", tt.name)
+		case tt.want != nil && err != nil:
+			t.Errorf("%s: validateProperties: %v", tt.name, err)
+		case !reflect.DeepEqual(got, tt.want) && tt.want != nil:
+			t.Errorf("%s: validateProperties = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	boolDef := &propertyDef{name: "brief", typ: propBool, def: false}
+	tests := []struct {
+		d    *propertyDef
+		s    string
+		want interface{} // nil if an error is expected
+	}{
+		{propertyDefs["width"], "80", 80},
+		{propertyDefs["width"], "0", 0},
+		{propertyDefs["width"], "-1", nil},
+		{propertyDefs["width"], "wide", nil},
+		{propertyDefs["color"], "ANSI", "ansi"},
+		{propertyDefs["color"], "truecolor", "truecolor"},
+		{propertyDefs["color"], "purple", nil},
+		{propertyDefs["charset"], "UTF-8", "utf-8"},
+		{propertyDefs["replyto"], "Anything At All", "Anything At All"},
+		{boolDef, "on", true},
+		{boolDef, "Yes", true},
+		{boolDef, "false", false},
+		{boolDef, "maybe", nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.d.parseValue(tt.s)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%s.parseValue(%q) = %v, want an error", tt.d.name, tt.s, got)
+		case tt.want != nil && (err != nil || got != tt.want):
+			t.Errorf("%s.parseValue(%q) = %v, %v, want %v", tt.d.name, tt.s, got, err, tt.want)
+		}
+	}
+}
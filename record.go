@@ -0,0 +1,125 @@
+package unimud
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Player records begin with a header containing a magic string and
+// a format version. Records written before the header was introduced
+// are treated as version 0.
+const (
+	playerRecordMagic   = "unimud-player\n"
+	playerRecordVersion = 1
+)
+
+// A playerMigration upgrades the properties of a player record from
+// one format version to the next.
+type playerMigration func(props map[string]interface{}) error
+
+// playerMigrations holds the migration from each old format version
+// to the version after it. When the record format or the type of a
+// property changes, bump playerRecordVersion and add a migration
+// from the previous version.
+var playerMigrations = map[int]playerMigration{
+	0: migratePlayerV0,
+}
+
+// migratePlayerV0 upgrades a headerless version 0 record. The room
+// id may have been stored as any integer type, but the game expects
+// an int.
+func migratePlayerV0(props map[string]interface{}) error {
+	switch id := props["room"].(type) {
+	case int:
+	case int32:
+		props["room"] = int(id)
+	case int64:
+		props["room"] = int(id)
+	case uint:
+		props["room"] = int(id)
+	case nil:
+		props["room"] = 0
+	default:
+		return fmt.Errorf("record: bad room id type %T", id)
+	}
+	return nil
+}
+
+var errRecordLoginMismatch = errors.New("record: login id mismatch")
+
+// encodePlayer writes a player record containing the player's login
+// id and properties to `w`, using the current format version.
+func encodePlayer(w io.Writer, login string, props map[string]interface{}) error {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], playerRecordVersion)
+	if _, err := io.WriteString(w, playerRecordMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(w)
+
+	if err := enc.Encode(login); err != nil {
+		return err
+	}
+	if err := enc.Encode(props); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// decodePlayer reads a player record from `r` and migrates its
+// properties to the current format version. It returns an error if
+// the record belongs to a player other than `login`.
+func decodePlayer(r io.Reader, login string) (map[string]interface{}, error) {
+	br := bufio.NewReader(r)
+
+	version := 0
+	if magic, err := br.Peek(len(playerRecordMagic)); err == nil &&
+		string(magic) == playerRecordMagic {
+		br.Discard(len(magic))
+
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		version = int(binary.BigEndian.Uint32(hdr[:]))
+	}
+	if version > playerRecordVersion {
+		return nil, fmt.Errorf("record: unsupported version %d", version)
+	}
+
+	dec := gob.NewDecoder(br)
+
+	var rlogin string
+	if err := dec.Decode(&rlogin); err != nil {
+		return nil, err
+	}
+	if rlogin != login {
+		return nil, errRecordLoginMismatch
+	}
+
+	var props map[string]interface{}
+	if err := dec.Decode(&props); err != nil {
+		return nil, err
+	}
+
+	// Apply migrations until the record is current.
+	for ; version < playerRecordVersion; version++ {
+		m, ok := playerMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("record: no migration from version %d", version)
+		}
+		if err := m(props); err != nil {
+			return nil, err
+		}
+	}
+	return props, nil
+}
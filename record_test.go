@@ -0,0 +1,119 @@
+package unimud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodePlayerV0 writes a headerless player record, as written
+// before the record format was versioned.
+func encodePlayerV0(t *testing.T, login string, props map[string]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(login); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(props); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPlayerRecordRoundTrip(t *testing.T) {
+	props := map[string]interface{}{
+		"room":  12,
+		"pw":    "pbkdf2-sha256$1$c2FsdA$a2V5",
+		"color": true,
+	}
+	var buf bytes.Buffer
+	if err := encodePlayer(&buf, "alice", props); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), playerRecordMagic) {
+		t.Errorf("record doesn't begin with the magic string: %q", buf.Bytes())
+	}
+
+	got, err := decodePlayer(bytes.NewReader(buf.Bytes()), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, props) {
+		t.Errorf("decodePlayer = %v, want %v", got, props)
+	}
+
+	if _, err := decodePlayer(bytes.NewReader(buf.Bytes()), "bob"); err != errRecordLoginMismatch {
+		t.Errorf("decodePlayer with the wrong login returned %v, want %v", err, errRecordLoginMismatch)
+	}
+}
+
+func TestPlayerRecordMigration(t *testing.T) {
+	tests := []struct {
+		name string
+		room interface{}
+		want interface{}
+		err  bool
+	}{
+		{"int", 3, 3, false},
+		{"int32", int32(4), 4, false},
+		{"int64", int64(5), 5, false},
+		{"uint", uint(6), 6, false},
+		{"missing", nil, 0, false},
+		{"string", "7", nil, true},
+		{"float", 8.0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			props := map[string]interface{}{"pw": "secret"}
+			if tt.room != nil {
+				props["room"] = tt.room
+			}
+			rec := encodePlayerV0(t, "alice", props)
+
+			got, err := decodePlayer(bytes.NewReader(rec), "alice")
+			if tt.err {
+				if err == nil {
+					t.Errorf("decodePlayer succeeded with room %v", got["room"])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["room"] != tt.want {
+				t.Errorf("room = %#v, want %#v", got["room"], tt.want)
+			}
+			if got["pw"] != "secret" {
+				t.Errorf("pw = %#v, want %q", got["pw"], "secret")
+			}
+		})
+	}
+}
+
+func TestPlayerRecordFutureVersion(t *testing.T) {
+	var buf bytes.Buffer
+	encodePlayer(&buf, "alice", map[string]interface{}{"room": 1})
+	rec := buf.Bytes()
+	binary.BigEndian.PutUint32(rec[len(playerRecordMagic):], playerRecordVersion+1)
+
+	_, err := decodePlayer(bytes.NewReader(rec), "alice")
+	if err == nil || !strings.Contains(err.Error(), "unsupported version") {
+		t.Errorf("decodePlayer returned %v, want an unsupported version error", err)
+	}
+}
+
+func TestPlayerRecordTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	encodePlayer(&buf, "alice", map[string]interface{}{"room": 1})
+	rec := buf.Bytes()
+
+	for _, n := range []int{0, len(playerRecordMagic) + 2, len(rec) - 1} {
+		if _, err := decodePlayer(bytes.NewReader(rec[:n]), "alice"); err == nil {
+			t.Errorf("decodePlayer of the first %d bytes succeeded", n)
+		}
+	}
+}
@@ -2,9 +2,6 @@ package unimud
 
 import (
 	"encoding/json"
-	"fmt"
-	"os"
-	"path"
 	"strings"
 )
 
@@ -17,6 +14,7 @@ type room struct {
 	Exits       []exit
 	game        *Game
 	players     []*player
+	timers      timerSet
 }
 
 type exit struct {
@@ -24,21 +22,17 @@ type exit struct {
 	ID   int
 }
 
-// Load a room with the requested ID from disk. Associate it with
-// the game `g`.
+// Load a room with the requested ID from the game's room store.
+// Associate it with the game `g`.
 func roomLoad(g *Game, ID int) (*room, error) {
-	filename := path.Join("rooms", fmt.Sprintf("%d.dat", ID))
-	f, err := os.Open(filename)
+	data, err := g.RoomStore.Load(ID)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
 	// Use json to decode the room's data.
-	dec := json.NewDecoder(f)
-
-	r := &room{game: g}
-	if err := dec.Decode(r); err != nil {
+	r := &room{game: g, timers: make(timerSet)}
+	if err := json.Unmarshal(data, r); err != nil {
 		return nil, err
 	}
 	return r, nil
@@ -46,8 +40,9 @@ func roomLoad(g *Game, ID int) (*room, error) {
 
 // Display the room's description to the player `p`.
 func (r *room) display(p *player) {
-	p.Println(r.Name)
+	p.Printf("{W}%s{x}\n", r.Name)
 	p.Println(r.Description)
+	p.sendRoomInfo(r)
 
 	var exits []string
 	for _, e := range r.Exits {
@@ -55,10 +50,14 @@ func (r *room) display(p *player) {
 	}
 
 	exitString := strings.Join(exits, ", ")
-	p.Printf("Exits: %s\n", exitString)
+	p.Printf("{g}Exits: %s{x}\n", exitString)
 
 	for _, op := range r.players {
-		if p != op {
+		switch {
+		case p == op:
+		case op.linkDead:
+			p.Printf("%s is standing here, staring blankly.\n", op.login)
+		default:
 			p.Printf("%s is standing here.\n", op.login)
 		}
 	}
@@ -69,7 +68,8 @@ func (r *room) playerEnter(p *player) {
 	r.Println(p.login, "entered the room.")
 	r.players = append(r.players, p)
 	p.room = r
-	p.properties["room"] = r.ID
+	p.setPropInt("room", r.ID)
+	p.sendCharStatus()
 }
 
 // Have the player leave the room.
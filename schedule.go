@@ -0,0 +1,199 @@
+package unimud
+
+import (
+	"container/heap"
+	"time"
+)
+
+// The game's scheduler calls functions on the game's Run goroutine
+// after a number of clock ticks, either once or repeatedly. Like
+// commands, scheduled functions have the game state to themselves
+// while they run. Delays given as durations are rounded up to a
+// whole number of ticks, and are measured in ticks once scheduled,
+// so a timer that is already waiting isn't affected by a change to
+// the tick rate.
+
+// A timer is a function scheduled to be called by the game's
+// scheduler.
+type timer struct {
+	game    *Game    // the game whose scheduler holds the timer
+	fn      func()   // the function to call when the timer fires
+	due     uint64   // the tick on which the timer next fires
+	every   uint64   // ticks between repeats (0 for a one-shot timer)
+	seq     uint64   // orders timers that fire on the same tick
+	index   int      // position in the scheduler's heap (-1 if not scheduled)
+	owner   timerSet // the set of timers the timer belongs to, if any
+	stopped bool     // true once the timer has been stopped or has fired for the last time
+}
+
+// Stop cancels the timer so that it doesn't fire again. It does
+// nothing if the timer is nil or already stopped. A timer may stop
+// itself while it runs.
+func (t *timer) Stop() {
+	if t == nil || t.stopped {
+		return
+	}
+	t.stopped = true
+	if t.index >= 0 {
+		heap.Remove(&t.game.timers, t.index)
+	}
+	if t.owner != nil {
+		delete(t.owner, t)
+	}
+}
+
+// A timerHeap orders scheduled timers by the tick on which they
+// fire. It implements heap.Interface.
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].due != h[j].due {
+		return h[i].due < h[j].due
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// afterTicks schedules `fn` to be called once, `n` clock ticks from
+// now. A timer scheduled for fewer than 1 tick fires on the next
+// tick.
+func (g *Game) afterTicks(n int, fn func()) *timer {
+	return g.schedule(n, 0, fn)
+}
+
+// everyTicks schedules `fn` to be called every `n` clock ticks until
+// the returned timer is stopped.
+func (g *Game) everyTicks(n int, fn func()) *timer {
+	if n < 1 {
+		n = 1
+	}
+	return g.schedule(n, n, fn)
+}
+
+// after schedules `fn` to be called once, after the duration `d`.
+func (g *Game) after(d time.Duration, fn func()) *timer {
+	return g.afterTicks(g.ticks(d), fn)
+}
+
+// every schedules `fn` to be called every time the duration `d`
+// passes, until the returned timer is stopped.
+func (g *Game) every(d time.Duration, fn func()) *timer {
+	return g.everyTicks(g.ticks(d), fn)
+}
+
+// ticks converts the duration `d` to a number of clock ticks,
+// rounding up.
+func (g *Game) ticks(d time.Duration) int {
+	rate := time.Duration(g.config.TickRate)
+	return int((d + rate - 1) / rate)
+}
+
+// schedule adds a timer that fires `delay` ticks from now and then
+// every `every` ticks if `every` is positive.
+func (g *Game) schedule(delay, every int, fn func()) *timer {
+	if delay < 1 {
+		delay = 1
+	}
+	g.timerSeq++
+	t := &timer{
+		game:  g,
+		fn:    fn,
+		due:   g.tick + uint64(delay),
+		every: uint64(every),
+		seq:   g.timerSeq,
+	}
+	heap.Push(&g.timers, t)
+	return t
+}
+
+// runTimers advances the game's clock by one tick and calls the
+// functions of all timers due on the new tick, in the order they
+// were scheduled.
+func (g *Game) runTimers() {
+	g.tick++
+	for len(g.timers) > 0 && g.timers[0].due <= g.tick {
+		t := heap.Pop(&g.timers).(*timer)
+
+		// Reschedule a repeating timer before calling its
+		// function, so that the function may stop it.
+		if t.every > 0 {
+			t.due += t.every
+			g.timerSeq++
+			t.seq = g.timerSeq
+			heap.Push(&g.timers, t)
+		} else {
+			t.stopped = true
+			if t.owner != nil {
+				delete(t.owner, t)
+			}
+		}
+		t.fn()
+	}
+}
+
+// A timerSet tracks the timers belonging to an object, such as a
+// player or room, so that they can be stopped together.
+type timerSet map[*timer]bool
+
+// add records that the timer `t` belongs to the set. It returns `t`.
+func (s timerSet) add(t *timer) *timer {
+	if !t.stopped {
+		t.owner = s
+		s[t] = true
+	}
+	return t
+}
+
+// stopAll stops every timer in the set.
+func (s timerSet) stopAll() {
+	for t := range s {
+		t.Stop()
+	}
+}
+
+// after schedules `fn` to be called once, after the duration `d`,
+// unless the player leaves the game first.
+func (p *player) after(d time.Duration, fn func()) *timer {
+	return p.timers.add(p.game.after(d, fn))
+}
+
+// every schedules `fn` to be called every time the duration `d`
+// passes, until the timer is stopped or the player leaves the game.
+func (p *player) every(d time.Duration, fn func()) *timer {
+	return p.timers.add(p.game.every(d, fn))
+}
+
+// after schedules `fn` to be called once, after the duration `d`.
+// Rooms stay loaded, so room timers run until they are stopped.
+func (r *room) after(d time.Duration, fn func()) *timer {
+	return r.timers.add(r.game.after(d, fn))
+}
+
+// every schedules `fn` to be called every time the duration `d`
+// passes, until the timer is stopped.
+func (r *room) every(d time.Duration, fn func()) *timer {
+	return r.timers.add(r.game.every(d, fn))
+}
@@ -0,0 +1,183 @@
+package unimud
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestScheduler returns a game whose clock is only advanced by
+// calling runTimers.
+func newTestScheduler(tickRate time.Duration) *Game {
+	cfg := DefaultConfig()
+	cfg.TickRate = Duration(tickRate)
+	return NewGame(cfg)
+}
+
+func TestTimerOrder(t *testing.T) {
+	g := newTestScheduler(time.Second)
+	var fired []string
+	record := func(name string) func() {
+		return func() { fired = append(fired, name) }
+	}
+
+	g.afterTicks(2, record("a2"))
+	g.afterTicks(1, record("b1"))
+	g.afterTicks(0, record("c1"))
+	g.everyTicks(1, record("r"))
+	g.afterTicks(3, record("d3"))
+	g.afterTicks(2, record("e2"))
+
+	var ticks [][]string
+	for i := 0; i < 4; i++ {
+		fired = nil
+		g.runTimers()
+		ticks = append(ticks, fired)
+	}
+
+	want := [][]string{
+		{"b1", "c1", "r"},
+		{"a2", "e2", "r"},
+		{"d3", "r"},
+		{"r"},
+	}
+	if !reflect.DeepEqual(ticks, want) {
+		t.Errorf("timers fired %q, want %q", ticks, want)
+	}
+}
+
+func TestTimerStop(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(g *Game, count *int)
+		want  int
+	}{
+		{"one-shot", func(g *Game, count *int) {
+			g.afterTicks(1, func() { *count++ })
+		}, 1},
+		{"stopped before firing", func(g *Game, count *int) {
+			g.afterTicks(2, func() { *count++ }).Stop()
+		}, 0},
+		{"repeating", func(g *Game, count *int) {
+			g.everyTicks(2, func() { *count++ })
+		}, 5},
+		{"stops itself", func(g *Game, count *int) {
+			var tm *timer
+			tm = g.everyTicks(1, func() {
+				*count++
+				if *count == 3 {
+					tm.Stop()
+				}
+			})
+		}, 3},
+		{"stops another", func(g *Game, count *int) {
+			victim := g.afterTicks(2, func() { *count += 100 })
+			g.afterTicks(1, func() { *count++; victim.Stop() })
+		}, 1},
+		{"stopped twice", func(g *Game, count *int) {
+			tm := g.afterTicks(1, func() { *count++ })
+			tm.Stop()
+			tm.Stop()
+		}, 0},
+		{"stopped after firing", func(g *Game, count *int) {
+			var tm *timer
+			tm = g.afterTicks(1, func() { *count++ })
+			g.afterTicks(2, func() { tm.Stop() })
+		}, 1},
+		{"nil", func(g *Game, count *int) {
+			var tm *timer
+			tm.Stop()
+		}, 0},
+	}
+	for _, tt := range tests {
+		g := newTestScheduler(time.Second)
+		count := 0
+		tt.setup(g, &count)
+		for i := 0; i < 10; i++ {
+			g.runTimers()
+		}
+		if count != tt.want {
+			t.Errorf("%s: fired %d times, want %d", tt.name, count, tt.want)
+		}
+	}
+}
+
+func TestTimerSet(t *testing.T) {
+	g := newTestScheduler(time.Second)
+	set := make(timerSet)
+	count := 0
+	set.add(g.everyTicks(1, func() { count++ }))
+	set.add(g.afterTicks(1, func() { count++ }))
+	set.add(g.afterTicks(3, func() { count += 100 }))
+	other := g.everyTicks(1, func() {})
+
+	g.runTimers()
+	if count != 2 || len(set) != 2 {
+		t.Fatalf("after one tick: count %d, %d timers in set, want 2 and 2", count, len(set))
+	}
+
+	set.stopAll()
+	if len(set) != 0 {
+		t.Errorf("%d timers left in set after stopAll", len(set))
+	}
+	for i := 0; i < 5; i++ {
+		g.runTimers()
+	}
+	if count != 2 {
+		t.Errorf("count %d after stopAll, want 2", count)
+	}
+	if other.stopped || len(g.timers) != 1 {
+		t.Errorf("stopAll affected a timer outside the set")
+	}
+
+	// A timer that has already fired isn't added.
+	done := g.afterTicks(1, func() {})
+	g.runTimers()
+	set.add(done)
+	if len(set) != 0 {
+		t.Errorf("finished timer added to set")
+	}
+}
+
+func TestTicks(t *testing.T) {
+	g := newTestScheduler(100 * time.Millisecond)
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{100 * time.Millisecond, 1},
+		{101 * time.Millisecond, 2},
+		{time.Second, 10},
+	}
+	for _, tt := range tests {
+		if got := g.ticks(tt.d); got != tt.want {
+			t.Errorf("ticks(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestShutdownTimer(t *testing.T) {
+	g := newTestScheduler(time.Second)
+	g.shutdownBegin(time.Hour, "test")
+	g.runTimers()
+	if g.shutdownNow {
+		t.Fatal("shutdown an hour early")
+	}
+	if !g.shutdownCancel() {
+		t.Fatal("shutdownCancel found no pending shutdown")
+	}
+	if g.shutdownCancel() {
+		t.Error("shutdownCancel cancelled a shutdown twice")
+	}
+	if len(g.timers) != 0 {
+		t.Errorf("%d timers left after cancelling", len(g.timers))
+	}
+
+	g.shutdownBegin(0, "test")
+	g.runTimers()
+	if !g.shutdownNow {
+		t.Error("shutdown not started when due")
+	}
+}
@@ -2,30 +2,207 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
 
 	"github.com/beevik/unimud"
 )
 
 var (
-	console bool
-	port    int
+	configFile string
+	console    bool
+	port       int
+	dbFile     string
+	importDir  string
+	exportDir  string
 )
 
 func init() {
+	flag.StringVar(&configFile, "config", "", "load settings from a JSON configuration file")
 	flag.BoolVar(&console, "c", false, "launch with a console listener")
-	flag.IntVar(&port, "port", 2000, "network listening port (use 0 for none)")
+	flag.IntVar(&port, "port", 2000, "network listening port, replacing configured listeners (use 0 for none)")
+	flag.StringVar(&dbFile, "db", "", "store players and rooms in a database file")
+	flag.StringVar(&importDir, "import", "", "import players/ and rooms/ from a directory into the database, then exit")
+	flag.StringVar(&exportDir, "export", "", "export players/ and rooms/ from the database to a directory, then exit")
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the server and returns the process exit status.
+func run() int {
 	flag.Parse()
 
-	game := unimud.NewGame()
-	if console {
-		go game.ListenConsole()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	game := unimud.NewGame(cfg)
+
+	if cfg.DBFile != "" {
+		db, err := unimud.OpenDB(cfg.DBFile)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		defer db.Close()
+
+		game.PlayerStore = unimud.NewDBPlayerStore(db)
+		game.RoomStore = unimud.NewDBRoomStore(db)
+
+		switch {
+		case importDir != "":
+			players, rooms, err := fileStores(importDir, false)
+			if err != nil {
+				log.Print(err)
+				return 1
+			}
+			return transfer(game.PlayerStore, game.RoomStore, players, rooms)
+		case exportDir != "":
+			players, rooms, err := fileStores(exportDir, true)
+			if err != nil {
+				log.Print(err)
+				return 1
+			}
+			return transfer(players, rooms, game.PlayerStore, game.RoomStore)
+		}
+	} else if importDir != "" || exportDir != "" {
+		log.Print("-import and -export require -db")
+		return 2
 	}
-	if port > 0 {
-		go game.ListenNet(port)
+
+	for _, lc := range cfg.Listeners {
+		if err := game.Listen(lc); err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+	if cfg.Console {
+		go game.ListenConsole()
 	}
 	go game.Run()
+	go handleSignals(game)
 	<-game.DoneChan
+
+	if err := game.Err(); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// loadConfig loads the configuration file, if any, and applies
+// any settings given on the command line.
+func loadConfig() (unimud.Config, error) {
+	cfg := unimud.DefaultConfig()
+	if configFile != "" {
+		var err error
+		if cfg, err = unimud.LoadConfig(configFile); err != nil {
+			return cfg, err
+		}
+	}
+
+	// Only flags that were explicitly set override the file.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "c":
+			cfg.Console = console
+		case "port":
+			// Replace the configured listeners with a single
+			// plaintext listener.
+			cfg.Listeners = nil
+			if port > 0 {
+				lc := unimud.ListenerConfig{Addr: fmt.Sprintf(":%d", port)}
+				cfg.Listeners = append(cfg.Listeners, lc)
+			}
+		case "db":
+			cfg.DBFile = dbFile
+		}
+	})
+	return cfg, nil
+}
+
+// handleSignals shuts the game down gracefully on SIGINT or SIGTERM
+// and reloads rooms and the configuration file on SIGHUP. A second
+// SIGINT or SIGTERM exits immediately.
+func handleSignals(game *unimud.Game) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	shuttingDown := false
+	for sig := range ch {
+		switch {
+		case sig == syscall.SIGHUP:
+			log.Print("Received SIGHUP; reloading.")
+			go reload(game)
+		case shuttingDown:
+			log.Printf("Received %v again; exiting immediately.", sig)
+			os.Exit(1)
+		default:
+			log.Printf("Received %v; shutting down.", sig)
+			shuttingDown = true
+			go game.Shutdown()
+		}
+	}
+}
+
+// reload reloads the configuration file and passes it to the game.
+// It waits for the game loop to take the request, so it runs on its
+// own goroutine to keep a busy game from holding up signal handling.
+func reload(game *unimud.Game) {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Configuration not reloaded: %v", err)
+		game.Reload(nil)
+	} else {
+		game.Reload(&cfg)
+	}
+}
+
+// fileStores returns the file-based player and room stores in the
+// directory `dir`. If `create` is true, their directories are
+// created if necessary. Otherwise the directories must already
+// exist, so that a mistyped import directory is reported instead of
+// importing nothing.
+func fileStores(dir string, create bool) (unimud.PlayerStore, unimud.RoomStore, error) {
+	for _, sub := range []string{"players", "rooms"} {
+		subdir := path.Join(dir, sub)
+		if create {
+			if err := os.MkdirAll(subdir, 0755); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		info, err := os.Stat(subdir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			return nil, nil, fmt.Errorf("%s is not a directory", subdir)
+		}
+	}
+	return unimud.NewFilePlayerStore(path.Join(dir, "players")),
+		unimud.NewFileRoomStore(path.Join(dir, "rooms")), nil
+}
+
+// transfer copies all players and rooms from the source stores to
+// the destination stores. It returns the process exit status.
+func transfer(dstPlayers unimud.PlayerStore, dstRooms unimud.RoomStore,
+	srcPlayers unimud.PlayerStore, srcRooms unimud.RoomStore) int {
+	if err := unimud.CopyPlayers(dstPlayers, srcPlayers); err != nil {
+		log.Print(err)
+		return 1
+	}
+	if err := unimud.CopyRooms(dstRooms, srcRooms); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
 }
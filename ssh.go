@@ -0,0 +1,310 @@
+package unimud
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The time allowed for an SSH client to complete its handshake.
+const sshHandshakeTimeout = 30 * time.Second
+
+var errSSHAuth = errors.New("ssh: authentication failed")
+
+// ListenSSH begins listening for SSH connections on the address
+// described by `lc`. The SSH user name is the player's login id, and
+// the player authenticates with their password or with a public key
+// registered on their character. Connections are accepted on a new
+// goroutine, so ListenSSH returns as soon as the listener is bound.
+func (g *Game) ListenSSH(lc ListenerConfig) error {
+	hostKey, err := lc.sshHostKey()
+	if err != nil {
+		return err
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback:  g.sshPasswordAuth,
+		PublicKeyCallback: g.sshPublicKeyAuth,
+		MaxAuthTries:      3,
+	}
+	cfg.AddHostKey(hostKey)
+
+	l, err := g.listenTCP(lc.Addr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Listening on", lc.Addr, "(SSH)")
+
+	// Track the listener.
+	g.listenerAdd(l)
+
+	go func() {
+		defer l.Close()
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				log.Printf("Listen on %s ended.", lc.Addr)
+				break
+			}
+			go g.serveSSH(nc, cfg)
+		}
+	}()
+	return nil
+}
+
+// sshHostKey returns the listener's SSH host key. It is read from
+// the configured file, or generated if the listener allows it.
+func (lc *ListenerConfig) sshHostKey() (ssh.Signer, error) {
+	if lc.HostKeyFile != "" {
+		pem, err := ioutil.ReadFile(lc.HostKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.ParsePrivateKey(pem)
+	}
+	if !lc.SelfSigned {
+		return nil, errors.New("ssh: no host key configured for " + lc.Addr)
+	}
+
+	// A generated key changes every time the server starts, so
+	// clients will complain. It's only suitable for development.
+	log.Printf("Generating a temporary SSH host key for %s.", lc.Addr)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// serveSSH performs the SSH handshake on a new connection and runs a
+// player on its first session channel.
+func (g *Game) serveSSH(nc net.Conn, cfg *ssh.ServerConfig) {
+	nc.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	sc, chans, reqs, err := ssh.NewServerConn(nc, cfg)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	nc.SetDeadline(time.Time{})
+	armReadTimeout(nc)
+	go ssh.DiscardRequests(reqs)
+
+	login := sc.Permissions.Extensions["login"]
+	started := false
+	for nch := range chans {
+		if nch.ChannelType() != "session" || started {
+			nch.Reject(ssh.Prohibited, "only a single session is supported")
+			continue
+		}
+		ch, requests, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		started = true
+		go g.serveSSHSession(sc, ch, requests, login)
+	}
+}
+
+// serveSSHSession handles the requests on a session channel. The
+// player starts playing when the client asks for a shell.
+func (g *Game) serveSSHSession(sc *ssh.ServerConn, ch ssh.Channel, requests <-chan *ssh.Request, login string) {
+	c := newConnSSH(sc, ch)
+	running := false
+	for req := range requests {
+		ok := false
+		switch req.Type {
+		case "shell":
+			if !running {
+				running, ok = true, true
+				go g.serveConn(c, login)
+			}
+
+		case "pty-req":
+			// The request is deliberately refused. Input is
+			// handled a line at a time, and granting a PTY would
+			// put the client's terminal in raw mode, leaving
+			// the server to echo and edit each line itself.
+			// Refused, the client stays in line mode and echoes
+			// and edits the line locally, at the cost of a
+			// warning that no PTY was allocated. The request
+			// still describes the client's terminal.
+			if term, width, height, parsed := parseSSHPtyReq(req.Payload); parsed {
+				if mode, known := colorModeFromTerminal(term); known {
+					c.lock.Lock()
+					c.colorCaps = mode
+					c.lock.Unlock()
+				}
+				c.setSize(width, height)
+			}
+
+		case "window-change":
+			if len(req.Payload) >= 8 {
+				c.setSize(int(binary.BigEndian.Uint32(req.Payload)),
+					int(binary.BigEndian.Uint32(req.Payload[4:])))
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+// newConnSSH creates a new connection using the SSH channel `ch`
+// for the input and output. Closing the connection closes the SSH
+// connection `sc`. The connection may outlive the player it was
+// created for, if it takes over another player's session.
+func newConnSSH(sc *ssh.ServerConn, ch ssh.Channel) *conn {
+	return &conn{
+		closer:    sshCloser{sc, ch},
+		closed:    make(chan struct{}),
+		finished:  make(chan struct{}),
+		out:       newOutputQueue(),
+		input:     bufio.NewScanner(ch),
+		output:    bufio.NewWriter(ch),
+		colorCaps: colorANSI,
+		width:     defaultWidth,
+		height:    defaultHeight,
+	}
+}
+
+// An sshCloser closes a session channel along with its SSH
+// connection.
+type sshCloser struct {
+	sc *ssh.ServerConn
+	ch ssh.Channel
+}
+
+func (c sshCloser) Close() error {
+	// Close the network connection first, in case a write on the
+	// channel is stuck.
+	err := c.sc.Close()
+	c.ch.Close()
+	return err
+}
+
+// parseSSHPtyReq extracts the terminal type and size from the
+// payload of a "pty-req" request (RFC 4254 section 6.2).
+func parseSSHPtyReq(b []byte) (term string, width, height int, ok bool) {
+	if len(b) < 4 {
+		return "", 0, 0, false
+	}
+	n := int(binary.BigEndian.Uint32(b))
+	if n > len(b)-4-8 {
+		return "", 0, 0, false
+	}
+	term = string(b[4 : 4+n])
+	b = b[4+n:]
+	width = int(binary.BigEndian.Uint32(b))
+	height = int(binary.BigEndian.Uint32(b[4:]))
+	return term, width, height, true
+}
+
+// sshLoadPlayer validates an SSH user name and loads the properties
+// of the player with that login id. It is called on the connection's
+// goroutine, so it uses a copy of the game's settings.
+func (g *Game) sshLoadPlayer(login string) (map[string]interface{}, error) {
+	cfg := g.settings()
+	n := utf8.RuneCountInString(login)
+	if n < cfg.LoginMinLen || n > cfg.LoginMaxLen || !validateLogin(login) {
+		return nil, errSSHAuth
+	}
+
+	props, err := g.PlayerStore.Load(login)
+	if err != nil {
+		return nil, errSSHAuth
+	}
+	props, err = validateProperties(login, props)
+	if err != nil {
+		log.Printf("SSH login %s: %v\n", login, err)
+		return nil, errSSHAuth
+	}
+	return props, nil
+}
+
+// sshPasswordAuth authenticates an SSH user with the password of
+// the player whose login id matches the user name.
+func (g *Game) sshPasswordAuth(c ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+	props, err := g.sshLoadPlayer(c.User())
+	if err != nil {
+		return nil, err
+	}
+
+	stored, _ := props["pw"].(string)
+	match, legacy := checkPassword(stored, string(pw))
+	if !match {
+		return nil, errSSHAuth
+	}
+
+	// Replace a plaintext password from an old player file with
+	// a hash. The upgrade is finished on the game's Run goroutine.
+	if legacy {
+		g.upgradePassword(c.User(), string(pw))
+	}
+	return sshPermissions(c.User()), nil
+}
+
+// sshPublicKeyAuth authenticates an SSH user with one of the public
+// keys registered on the player whose login id matches the user
+// name.
+func (g *Game) sshPublicKeyAuth(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	props, err := g.sshLoadPlayer(c.User())
+	if err != nil {
+		return nil, err
+	}
+
+	keys, _ := props["sshkeys"].(string)
+	for _, k := range sshKeyList(keys) {
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err == nil && bytes.Equal(pk.Marshal(), key.Marshal()) {
+			return sshPermissions(c.User()), nil
+		}
+	}
+	return nil, errSSHAuth
+}
+
+// sshPermissions records the authenticated login id so it can be
+// retrieved once the connection is established.
+func sshPermissions(login string) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{"login": login},
+	}
+}
+
+// sshKeyList splits the value of the "sshkeys" property into its
+// individual authorized_keys lines.
+func sshKeyList(keys string) []string {
+	var list []string
+	for _, k := range strings.Split(keys, "\n") {
+		if k = strings.TrimSpace(k); k != "" {
+			list = append(list, k)
+		}
+	}
+	return list
+}
+
+// parseSSHKey parses a public key in authorized_keys format. It
+// returns the key in normalized form along with its fingerprint.
+func parseSSHKey(line string) (key, fingerprint string, err error) {
+	pk, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk)))
+	if comment != "" {
+		key += " " + comment
+	}
+	return key, ssh.FingerprintSHA256(pk), nil
+}
@@ -0,0 +1,141 @@
+package unimud
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// listenTestSSH adds an SSH listener with a generated host key to
+// the game and returns its address.
+func listenTestSSH(t *testing.T, g *Game) string {
+	t.Helper()
+	if err := g.ListenSSH(ListenerConfig{Addr: "127.0.0.1:0", SSH: true, SelfSigned: true}); err != nil {
+		t.Fatal(err)
+	}
+	g.listenersLock.Lock()
+	defer g.listenersLock.Unlock()
+	return g.listeners[len(g.listeners)-1].Addr().String()
+}
+
+// dialTestSSH reports whether user `login` can authenticate with the
+// SSH listener at `addr`.
+func dialTestSSH(t *testing.T, addr, login string, auth ssh.AuthMethod) bool {
+	t.Helper()
+	sc, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            login,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         testTimeout,
+	})
+	if err != nil {
+		if !strings.Contains(err.Error(), "unable to authenticate") {
+			t.Fatal(err)
+		}
+		return false
+	}
+	sc.Close()
+	return true
+}
+
+func TestSSHKeyCommand(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	sshAddr := listenTestSSH(t, g)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := ssh.PublicKeys(signer)
+
+	c := loginTestPlayer(t, addr, "alice")
+	if dialTestSSH(t, sshAddr, "alice", auth) {
+		t.Fatal("SSH login succeeded before the key was added")
+	}
+
+	// The key can be used while the player is still playing.
+	c.send("sshkey add " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk))) + " alice@home")
+	c.expect("Added SSH key " + ssh.FingerprintSHA256(pk) + ".")
+	if !dialTestSSH(t, sshAddr, "alice", auth) {
+		t.Error("SSH login failed after the key was added")
+	}
+	if dialTestSSH(t, sshAddr, "bobby", auth) {
+		t.Error("key accepted for another player")
+	}
+
+	c.send("sshkey")
+	c.expect("1: " + ssh.FingerprintSHA256(pk))
+	c.send("sshkey remove 2")
+	c.expect("No such SSH key.")
+	c.send("sshkey remove 1")
+	c.expect("Removed SSH key 1.")
+	if dialTestSSH(t, sshAddr, "alice", auth) {
+		t.Error("SSH login succeeded after the key was removed")
+	}
+
+	c.send("sshkey add ssh-ed25519 garbage")
+	c.expect("That isn't a valid SSH public key.")
+}
+
+func TestSSHPasswordLogin(t *testing.T) {
+	g, addr := newTestGame(t, nil)
+	sshAddr := listenTestSSH(t, g)
+
+	c := loginTestPlayer(t, addr, "alice")
+	c.send("quit")
+	c.closed()
+
+	if dialTestSSH(t, sshAddr, "alice", ssh.Password("wrong")) {
+		t.Error("SSH login succeeded with the wrong password")
+	}
+	if dialTestSSH(t, sshAddr, "carol", ssh.Password("secret")) {
+		t.Error("SSH login succeeded for a missing player")
+	}
+
+	sc, err := ssh.Dial("tcp", sshAddr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         testTimeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	s, err := sc.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The player skips the login prompts and enters the world.
+	timer := time.AfterFunc(testTimeout, func() { sc.Close() })
+	defer timer.Stop()
+	var text strings.Builder
+	buf := make([]byte, 256)
+	for !strings.Contains(text.String(), "Hall") {
+		n, err := out.Read(buf)
+		if err != nil {
+			t.Fatalf("reading SSH session: %v; got %q", err, text.String())
+		}
+		text.Write(buf[:n])
+	}
+}
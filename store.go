@@ -0,0 +1,474 @@
+package unimud
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrPlayerNotFound is returned by a PlayerStore when the requested
+// player doesn't exist.
+var ErrPlayerNotFound = errors.New("store: player not found")
+
+// A PlayerStore persists the properties of players between game
+// sessions. Players are identified by their login ids.
+// Implementations must be safe for concurrent use, since listeners
+// that authenticate players themselves, such as SSH, load players
+// on their connection's goroutine while the game runs.
+type PlayerStore interface {
+	// Load returns the stored properties of a player.
+	Load(login string) (map[string]interface{}, error)
+
+	// Save stores the properties of a player, replacing any
+	// previously stored properties.
+	Save(login string, props map[string]interface{}) error
+
+	// Exists returns true if a player has been stored.
+	Exists(login string) (bool, error)
+
+	// Delete removes a player from the store.
+	Delete(login string) error
+
+	// List returns the login ids of all stored players.
+	List() ([]string, error)
+}
+
+// A FilePlayerStore stores each player as a gob-encoded file in a
+// directory. Saves are atomic, and the previous few saves of each
+// player are kept as backup files.
+type FilePlayerStore struct {
+	dir     string
+	Backups int // number of backup files kept per player
+}
+
+// NewFilePlayerStore creates a player store that keeps its files in
+// the directory `dir`.
+func NewFilePlayerStore(dir string) *FilePlayerStore {
+	return &FilePlayerStore{dir: dir, Backups: 3}
+}
+
+func (s *FilePlayerStore) filename(login string) string {
+	return path.Join(s.dir, login+".dat")
+}
+
+// Load reads a player's file and returns its properties.
+func (s *FilePlayerStore) Load(login string) (map[string]interface{}, error) {
+	f, err := os.Open(s.filename(login))
+	if os.IsNotExist(err) {
+		return nil, ErrPlayerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decodePlayer(f, login)
+}
+
+// Save writes a player's properties to a temporary file and then
+// renames it over the player's file, so a crash can never leave a
+// partially written player behind. The directory is synced after
+// the rename, so that the new file survives a power failure.
+func (s *FilePlayerStore) Save(login string, props map[string]interface{}) error {
+	filename := s.filename(login)
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = encodePlayer(f, login, props)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := s.backup(filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// backup rotates the player file's backups and makes a new backup
+// of the current file. The current file is left in place.
+func (s *FilePlayerStore) backup(filename string) error {
+	if s.Backups < 1 {
+		return nil
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+
+	bak := func(n int) string { return fmt.Sprintf("%s.bak%d", filename, n) }
+	os.Remove(bak(s.Backups))
+	for n := s.Backups - 1; n >= 1; n-- {
+		if err := os.Rename(bak(n), bak(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Prefer a hard link, since it's cheap. Fall back to a copy on
+	// file systems that don't support links.
+	if err := os.Link(filename, bak(1)); err != nil {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(bak(1), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	// Make sure the rotated backups reach the disk before the
+	// player's file is replaced.
+	return syncDir(s.dir)
+}
+
+// Exists returns true if the player's file exists.
+func (s *FilePlayerStore) Exists(login string) (bool, error) {
+	_, err := os.Stat(s.filename(login))
+	switch {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Delete removes the player's file.
+func (s *FilePlayerStore) Delete(login string) error {
+	err := os.Remove(s.filename(login))
+	if os.IsNotExist(err) {
+		return ErrPlayerNotFound
+	}
+	return err
+}
+
+// List returns the login ids of all player files in the store's
+// directory.
+func (s *FilePlayerStore) List() ([]string, error) {
+	f, err := os.Open(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var logins []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".dat") {
+			logins = append(logins, strings.TrimSuffix(name, ".dat"))
+		}
+	}
+	sort.Strings(logins)
+	return logins, nil
+}
+
+// A MemPlayerStore keeps players in memory. It is useful for tests
+// and for games that don't need persistence.
+type MemPlayerStore struct {
+	lock    sync.Mutex
+	players map[string]map[string]interface{}
+}
+
+// NewMemPlayerStore creates an empty in-memory player store.
+func NewMemPlayerStore() *MemPlayerStore {
+	return &MemPlayerStore{
+		players: make(map[string]map[string]interface{}),
+	}
+}
+
+// Load returns a copy of the player's stored properties.
+func (s *MemPlayerStore) Load(login string) (map[string]interface{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	props, ok := s.players[login]
+	if !ok {
+		return nil, ErrPlayerNotFound
+	}
+	return copyProperties(props), nil
+}
+
+// Save stores a copy of the player's properties.
+func (s *MemPlayerStore) Save(login string, props map[string]interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.players[login] = copyProperties(props)
+	return nil
+}
+
+// Exists returns true if the player is in the store.
+func (s *MemPlayerStore) Exists(login string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.players[login]
+	return ok, nil
+}
+
+// Delete removes the player from the store.
+func (s *MemPlayerStore) Delete(login string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.players[login]; !ok {
+		return ErrPlayerNotFound
+	}
+	delete(s.players, login)
+	return nil
+}
+
+// List returns the login ids of all players in the store.
+func (s *MemPlayerStore) List() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var logins []string
+	for login := range s.players {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	return logins, nil
+}
+
+// A DBPlayerStore keeps players in the "players" bucket of a DB.
+type DBPlayerStore struct {
+	db *DB
+}
+
+// The DB bucket containing player records.
+const dbPlayerBucket = "players"
+
+// NewDBPlayerStore creates a player store backed by the database
+// `db`.
+func NewDBPlayerStore(db *DB) *DBPlayerStore {
+	return &DBPlayerStore{db: db}
+}
+
+// Load decodes and returns the player's stored properties.
+func (s *DBPlayerStore) Load(login string) (map[string]interface{}, error) {
+	data, ok := s.db.Get(dbPlayerBucket, login)
+	if !ok {
+		return nil, ErrPlayerNotFound
+	}
+	return decodePlayer(bytes.NewReader(data), login)
+}
+
+// Save encodes the player's properties and stores them in a single
+// transaction.
+func (s *DBPlayerStore) Save(login string, props map[string]interface{}) error {
+	var buf bytes.Buffer
+	if err := encodePlayer(&buf, login, props); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *Tx) error {
+		tx.Put(dbPlayerBucket, login, buf.Bytes())
+		return nil
+	})
+}
+
+// Exists returns true if the player is in the database.
+func (s *DBPlayerStore) Exists(login string) (bool, error) {
+	_, ok := s.db.Get(dbPlayerBucket, login)
+	return ok, nil
+}
+
+// Delete removes the player from the database.
+func (s *DBPlayerStore) Delete(login string) error {
+	return s.db.Update(func(tx *Tx) error {
+		if _, ok := tx.Get(dbPlayerBucket, login); !ok {
+			return ErrPlayerNotFound
+		}
+		tx.Delete(dbPlayerBucket, login)
+		return nil
+	})
+}
+
+// List returns the login ids of all players in the database.
+func (s *DBPlayerStore) List() ([]string, error) {
+	return s.db.Keys(dbPlayerBucket), nil
+}
+
+// CopyPlayers copies every player in the store `src` to the store
+// `dst`. It can be used to migrate players between backends.
+func CopyPlayers(dst, src PlayerStore) error {
+	logins, err := src.List()
+	if err != nil {
+		return err
+	}
+	for _, login := range logins {
+		props, err := src.Load(login)
+		if err != nil {
+			return fmt.Errorf("store: loading player %s: %v", login, err)
+		}
+		if err := dst.Save(login, props); err != nil {
+			return fmt.Errorf("store: saving player %s: %v", login, err)
+		}
+	}
+	return nil
+}
+
+// copyProperties returns a shallow copy of a property map.
+func copyProperties(props map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		c[k] = v
+	}
+	return c
+}
+
+// ErrRoomNotFound is returned by a RoomStore when the requested room
+// doesn't exist.
+var ErrRoomNotFound = errors.New("store: room not found")
+
+// A RoomStore holds the definitions of rooms. Each definition is a
+// JSON document describing a room's ID, name, description and exits.
+type RoomStore interface {
+	// Load returns the definition of a room.
+	Load(id int) ([]byte, error)
+
+	// Save stores the definition of a room, replacing any previous
+	// definition.
+	Save(id int, data []byte) error
+
+	// List returns the IDs of all stored rooms.
+	List() ([]int, error)
+}
+
+// A FileRoomStore stores each room definition as a file in a
+// directory.
+type FileRoomStore struct {
+	dir string
+}
+
+// NewFileRoomStore creates a room store that keeps its files in the
+// directory `dir`.
+func NewFileRoomStore(dir string) *FileRoomStore {
+	return &FileRoomStore{dir: dir}
+}
+
+func (s *FileRoomStore) filename(id int) string {
+	return path.Join(s.dir, fmt.Sprintf("%d.dat", id))
+}
+
+// Load reads the room's file.
+func (s *FileRoomStore) Load(id int) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.filename(id))
+	if os.IsNotExist(err) {
+		return nil, ErrRoomNotFound
+	}
+	return data, err
+}
+
+// Save writes the room's file.
+func (s *FileRoomStore) Save(id int, data []byte) error {
+	return ioutil.WriteFile(s.filename(id), data, 0644)
+}
+
+// List returns the IDs of all room files in the store's directory.
+func (s *FileRoomStore) List() ([]int, error) {
+	f, err := os.Open(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".dat") {
+			continue
+		}
+		if id, err := strconv.Atoi(strings.TrimSuffix(name, ".dat")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// A DBRoomStore keeps room definitions in the "rooms" bucket of a
+// DB.
+type DBRoomStore struct {
+	db *DB
+}
+
+// The DB bucket containing room definitions.
+const dbRoomBucket = "rooms"
+
+// NewDBRoomStore creates a room store backed by the database `db`.
+func NewDBRoomStore(db *DB) *DBRoomStore {
+	return &DBRoomStore{db: db}
+}
+
+// Load returns the room's definition.
+func (s *DBRoomStore) Load(id int) ([]byte, error) {
+	data, ok := s.db.Get(dbRoomBucket, strconv.Itoa(id))
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return data, nil
+}
+
+// Save stores the room's definition in a single transaction.
+func (s *DBRoomStore) Save(id int, data []byte) error {
+	return s.db.Update(func(tx *Tx) error {
+		tx.Put(dbRoomBucket, strconv.Itoa(id), data)
+		return nil
+	})
+}
+
+// List returns the IDs of all rooms in the database.
+func (s *DBRoomStore) List() ([]int, error) {
+	var ids []int
+	for _, key := range s.db.Keys(dbRoomBucket) {
+		if id, err := strconv.Atoi(key); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// CopyRooms copies every room in the store `src` to the store `dst`.
+// It can be used to migrate rooms between backends.
+func CopyRooms(dst, src RoomStore) error {
+	ids, err := src.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		data, err := src.Load(id)
+		if err != nil {
+			return fmt.Errorf("store: loading room %d: %v", id, err)
+		}
+		if err := dst.Save(id, data); err != nil {
+			return fmt.Errorf("store: saving room %d: %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package unimud
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testPlayerStores returns an empty player store of each kind.
+func testPlayerStores(t *testing.T) map[string]PlayerStore {
+	t.Helper()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return map[string]PlayerStore{
+		"file": NewFilePlayerStore(t.TempDir()),
+		"mem":  NewMemPlayerStore(),
+		"db":   NewDBPlayerStore(db),
+	}
+}
+
+func TestPlayerStore(t *testing.T) {
+	alice := map[string]interface{}{"pw": "secret", "room": 3}
+	bob := map[string]interface{}{"pw": "hunter2", "room": 0, "color": "ansi"}
+
+	for name, s := range testPlayerStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.Load("alice"); err != ErrPlayerNotFound {
+				t.Errorf("Load of a missing player returned %v, want %v", err, ErrPlayerNotFound)
+			}
+			if ok, err := s.Exists("alice"); ok || err != nil {
+				t.Errorf("Exists of a missing player = %v, %v", ok, err)
+			}
+			if err := s.Delete("alice"); err != ErrPlayerNotFound {
+				t.Errorf("Delete of a missing player returned %v, want %v", err, ErrPlayerNotFound)
+			}
+
+			if err := s.Save("alice", alice); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save("bob", bob); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.Load("alice")
+			if err != nil || !reflect.DeepEqual(got, alice) {
+				t.Errorf("Load = %v, %v, want %v", got, err, alice)
+			}
+
+			// The store keeps its own copy.
+			got["room"] = 99
+			if again, _ := s.Load("alice"); again["room"] != 3 {
+				t.Errorf("changing a loaded player changed the store: room %v", again["room"])
+			}
+
+			if ok, err := s.Exists("bob"); !ok || err != nil {
+				t.Errorf("Exists = %v, %v, want true", ok, err)
+			}
+			if logins, err := s.List(); err != nil || !reflect.DeepEqual(logins, []string{"alice", "bob"}) {
+				t.Errorf("List = %q, %v", logins, err)
+			}
+
+			if err := s.Delete("alice"); err != nil {
+				t.Fatal(err)
+			}
+			if logins, err := s.List(); err != nil || !reflect.DeepEqual(logins, []string{"bob"}) {
+				t.Errorf("List after Delete = %q, %v", logins, err)
+			}
+		})
+	}
+}
+
+func TestCopyPlayers(t *testing.T) {
+	players := map[string]map[string]interface{}{
+		"alice": {"pw": "secret", "room": 3},
+		"bob":   {"pw": "hunter2", "room": 0},
+	}
+	src := NewMemPlayerStore()
+	for login, props := range players {
+		src.Save(login, props)
+	}
+
+	for name, dst := range testPlayerStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := CopyPlayers(dst, src); err != nil {
+				t.Fatal(err)
+			}
+			for login, want := range players {
+				got, err := dst.Load(login)
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("Load(%s) = %v, %v, want %v", login, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyRooms(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	src := NewFileRoomStore(t.TempDir())
+	rooms := map[int]string{0: `{"name":"Hall"}`, 12: `{"name":"Attic"}`}
+	for id, data := range rooms {
+		if err := src.Save(id, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := NewDBRoomStore(db)
+	if err := CopyRooms(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := dst.List(); err != nil || !reflect.DeepEqual(ids, []int{0, 12}) {
+		t.Errorf("List = %v, %v", ids, err)
+	}
+	for id, want := range rooms {
+		if got, err := dst.Load(id); err != nil || string(got) != want {
+			t.Errorf("Load(%d) = %q, %v, want %q", id, got, err, want)
+		}
+	}
+	if _, err := dst.Load(5); err != ErrRoomNotFound {
+		t.Errorf("Load of a missing room returned %v, want %v", err, ErrRoomNotFound)
+	}
+}
+
+func TestFilePlayerStoreBackups(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFilePlayerStore(dir)
+	s.Backups = 2
+	for room := 1; room <= 4; room++ {
+		if err := s.Save("alice", map[string]interface{}{"room": room}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The newest backup holds the previous save.
+	tests := []struct {
+		file string
+		room int
+	}{
+		{"alice.dat", 4},
+		{"alice.dat.bak1", 3},
+		{"alice.dat.bak2", 2},
+	}
+	for _, tt := range tests {
+		f, err := os.Open(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		props, err := decodePlayer(f, "alice")
+		f.Close()
+		if err != nil || props["room"] != tt.room {
+			t.Errorf("%s has room %v, %v, want %d", tt.file, props["room"], err, tt.room)
+		}
+	}
+	for _, name := range []string{"alice.dat.bak3", "alice.dat.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s exists: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package unimud
+
+import (
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// Telnet command codes (RFC 854).
+const (
+	telnetSE   byte = 240 // end of subnegotiation
+	telnetNOP  byte = 241 // no operation
+	telnetDM   byte = 242 // data mark
+	telnetBRK  byte = 243 // break
+	telnetIP   byte = 244 // interrupt process
+	telnetAO   byte = 245 // abort output
+	telnetAYT  byte = 246 // are you there
+	telnetEC   byte = 247 // erase character
+	telnetEL   byte = 248 // erase line
+	telnetGA   byte = 249 // go ahead
+	telnetSB   byte = 250 // begin subnegotiation
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255 // interpret as command
+)
+
+// Telnet option codes.
+const (
+	optEcho    byte = 1   // RFC 857
+	optSGA     byte = 3   // RFC 858, suppress go-ahead
+	optTTYPE   byte = 24  // RFC 1091, terminal type
+	optNAWS    byte = 31  // RFC 1073, negotiate about window size
+	optCharset byte = 42  // RFC 2066, character set
+	optMCCP2   byte = 86  // MUD Client Compression Protocol v2
+	optGMCP    byte = 201 // Generic MUD Communication Protocol
+)
+
+// CHARSET subnegotiation commands.
+const (
+	charsetRequest  byte = 1
+	charsetAccepted byte = 2
+	charsetRejected byte = 3
+)
+
+// TTYPE subnegotiation commands.
+const (
+	ttypeIS   byte = 0
+	ttypeSEND byte = 1
+)
+
+// The maximum number of bytes accepted in a single subnegotiation.
+// Anything longer is truncated.
+const maxSubnegotiation = 8192
+
+// qState is the state of one side of a telnet option, as described
+// by the Q method of option negotiation (RFC 1143).
+type qState byte
+
+const (
+	qNo qState = iota
+	qYes
+	qWantNo
+	qWantYes
+)
+
+// A qSide tracks the negotiation state of one side (local or
+// remote) of a telnet option.
+type qSide struct {
+	state    qState // current negotiation state
+	opposite bool   // true if the opposite request is queued
+	allow    bool   // true if the other end may enable this side
+}
+
+// A telnetOption tracks both sides of a single telnet option. The
+// local side ("us") is negotiated with WILL/WONT and the remote side
+// ("him") with DO/DONT.
+type telnetOption struct {
+	us  qSide
+	him qSide
+}
+
+// Parser states for the telnet input stream.
+type telnetReadState byte
+
+const (
+	tsData telnetReadState = iota
+	tsCR
+	tsIAC
+	tsWill
+	tsWont
+	tsDo
+	tsDont
+	tsSB
+	tsSBData
+	tsSBIAC
+)
+
+// A telnet implements the telnet protocol on top of a raw network
+// stream. Reading from it returns the data stream with all telnet
+// commands stripped and handled. Writing to it escapes data so that
+// it can't be confused with telnet commands.
+type telnet struct {
+	r        io.Reader                           // the raw input stream
+	w        io.Writer                           // the raw output stream
+	wlock    sync.Mutex                          // serializes writes to w
+	lock     sync.Mutex                          // protects the option table
+	options  [256]telnetOption                   // negotiation state of every option
+	state    telnetReadState                     // current input parser state
+	sbOpt    byte                                // option of the active subnegotiation
+	sbData   []byte                              // data of the active subnegotiation
+	rbuf     []byte                              // raw input buffer
+	lastCR   bool                                // true if the last byte written was a CR
+	onChange func(opt byte, local, enabled bool) // called when an option changes state
+	onSub    func(opt byte, data []byte)         // called when a subnegotiation completes
+	z        *zlib.Writer                        // compressor for the output stream (nil if not compressing)
+	zlevel   int                                 // zlib level used to compress the output stream
+	rawOut   int64                               // bytes of protocol data written
+	wireOut  int64                               // bytes sent over the network
+}
+
+// newTelnet creates a telnet protocol layer that reads raw protocol
+// data from `r` and writes raw protocol data to `w`.
+func newTelnet(r io.Reader, w io.Writer) *telnet {
+	return &telnet{
+		r:      r,
+		w:      w,
+		rbuf:   make([]byte, 4096),
+		zlevel: defaultCompressionLevel,
+	}
+}
+
+// allow sets whether the remote end of the connection may enable
+// the local and remote sides of option `opt`.
+func (t *telnet) allow(opt byte, local, remote bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.options[opt].us.allow = local
+	t.options[opt].him.allow = remote
+}
+
+// localEnabled returns true if option `opt` has been negotiated on
+// for the local (server) side of the connection.
+func (t *telnet) localEnabled(opt byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.options[opt].us.state == qYes
+}
+
+// remoteEnabled returns true if option `opt` has been negotiated
+// on for the remote (client) side of the connection.
+func (t *telnet) remoteEnabled(opt byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.options[opt].him.state == qYes
+}
+
+// Read fills `b` with data from the telnet stream. All telnet
+// commands are removed from the data and handled before returning.
+func (t *telnet) Read(b []byte) (int, error) {
+	for {
+		// Never read more raw bytes than can be returned.
+		n := len(b)
+		if n > len(t.rbuf) {
+			n = len(t.rbuf)
+		}
+
+		rn, err := t.r.Read(t.rbuf[:n])
+		out := 0
+		for _, c := range t.rbuf[:rn] {
+			if d, ok := t.parse(c); ok {
+				b[out] = d
+				out++
+			}
+		}
+
+		// Keep reading if only commands arrived, since a zero-byte
+		// read would look like a stalled stream to the caller.
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// parse runs a single byte through the input state machine. It
+// returns the data byte to output and true if the byte is part of
+// the data stream.
+func (t *telnet) parse(c byte) (byte, bool) {
+	switch t.state {
+	case tsData:
+		switch c {
+		case telnetIAC:
+			t.state = tsIAC
+			return 0, false
+		case '\r':
+			t.state = tsCR
+		}
+		return c, true
+
+	case tsCR:
+		// CR NUL is a bare carriage return, which some clients send
+		// at the end of a line. Treat it like CR LF.
+		t.state = tsData
+		switch c {
+		case 0:
+			return '\n', true
+		case telnetIAC:
+			t.state = tsIAC
+			return 0, false
+		case '\r':
+			t.state = tsCR
+		}
+		return c, true
+
+	case tsIAC:
+		t.state = tsData
+		switch c {
+		case telnetIAC:
+			return c, true // escaped data byte
+		case telnetWILL:
+			t.state = tsWill
+		case telnetWONT:
+			t.state = tsWont
+		case telnetDO:
+			t.state = tsDo
+		case telnetDONT:
+			t.state = tsDont
+		case telnetSB:
+			t.state = tsSB
+		}
+		return 0, false
+
+	case tsWill, tsWont, tsDo, tsDont:
+		cmd := t.state
+		t.state = tsData
+		t.negotiate(cmd, c)
+		return 0, false
+
+	case tsSB:
+		t.sbOpt = c
+		t.sbData = t.sbData[:0]
+		t.state = tsSBData
+		return 0, false
+
+	case tsSBData:
+		if c == telnetIAC {
+			t.state = tsSBIAC
+		} else if len(t.sbData) < maxSubnegotiation {
+			t.sbData = append(t.sbData, c)
+		}
+		return 0, false
+
+	case tsSBIAC:
+		switch c {
+		case telnetIAC:
+			if len(t.sbData) < maxSubnegotiation {
+				t.sbData = append(t.sbData, c)
+			}
+			t.state = tsSBData
+		case telnetSE:
+			t.state = tsData
+			if t.onSub != nil {
+				t.onSub(t.sbOpt, t.sbData)
+			}
+		default:
+			// Protocol violation. Abandon the subnegotiation.
+			t.state = tsData
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// negotiate handles an incoming WILL, WONT, DO or DONT command for
+// option `opt`, following the rules of RFC 1143.
+func (t *telnet) negotiate(cmd telnetReadState, opt byte) {
+	t.lock.Lock()
+	o := &t.options[opt]
+
+	side, local := &o.him, false
+	if cmd == tsDo || cmd == tsDont {
+		side, local = &o.us, true
+	}
+	yes, no := telnetDO, telnetDONT
+	if local {
+		yes, no = telnetWILL, telnetWONT
+	}
+
+	was := side.state == qYes
+	var reply byte
+	if cmd == tsWill || cmd == tsDo {
+		reply = side.receiveEnable(yes, no)
+	} else {
+		reply = side.receiveDisable(yes, no)
+	}
+	enabled := side.state == qYes
+	t.lock.Unlock()
+
+	if reply != 0 {
+		t.sendCommand(reply, opt)
+	}
+	if was != enabled && t.onChange != nil {
+		t.onChange(opt, local, enabled)
+	}
+}
+
+// receiveEnable handles a request from the other end to enable this
+// side of an option. It returns the command to send in reply, or 0
+// if no reply is necessary.
+func (q *qSide) receiveEnable(yes, no byte) byte {
+	switch q.state {
+	case qNo:
+		if q.allow {
+			q.state = qYes
+			return yes
+		}
+		return no
+	case qWantNo:
+		if q.opposite {
+			q.state, q.opposite = qYes, false
+		} else {
+			// The other end answered our disable request with
+			// an enable.
+			q.state = qNo
+		}
+	case qWantYes:
+		if q.opposite {
+			q.state, q.opposite = qWantNo, false
+			return no
+		}
+		q.state = qYes
+	}
+	return 0
+}
+
+// receiveDisable handles a request from the other end to disable
+// this side of an option. It returns the command to send in reply,
+// or 0 if no reply is necessary.
+func (q *qSide) receiveDisable(yes, no byte) byte {
+	switch q.state {
+	case qYes:
+		q.state = qNo
+		return no
+	case qWantNo:
+		if q.opposite {
+			q.state, q.opposite = qWantYes, false
+			return yes
+		}
+		q.state = qNo
+	case qWantYes:
+		q.state, q.opposite = qNo, false
+	}
+	return 0
+}
+
+// requestEnable asks for this side of an option to be enabled. It
+// returns the command to send to the other end (or 0 if none).
+func (q *qSide) requestEnable(yes byte) byte {
+	switch q.state {
+	case qNo:
+		q.state = qWantYes
+		return yes
+	case qWantNo:
+		q.opposite = true
+	case qWantYes:
+		q.opposite = false
+	}
+	return 0
+}
+
+// requestDisable asks for this side of an option to be disabled. It
+// returns the command to send to the other end (or 0 if none).
+func (q *qSide) requestDisable(no byte) byte {
+	switch q.state {
+	case qYes:
+		q.state = qWantNo
+		return no
+	case qWantNo:
+		q.opposite = false
+	case qWantYes:
+		q.opposite = true
+	}
+	return 0
+}
+
+// enableLocal asks the remote end to let us enable option `opt`.
+func (t *telnet) enableLocal(opt byte) {
+	t.lock.Lock()
+	cmd := t.options[opt].us.requestEnable(telnetWILL)
+	t.lock.Unlock()
+	if cmd != 0 {
+		t.sendCommand(cmd, opt)
+	}
+}
+
+// disableLocal tells the remote end we are disabling option `opt`.
+func (t *telnet) disableLocal(opt byte) {
+	t.lock.Lock()
+	cmd := t.options[opt].us.requestDisable(telnetWONT)
+	t.lock.Unlock()
+	if cmd != 0 {
+		t.sendCommand(cmd, opt)
+	}
+}
+
+// enableRemote asks the remote end to enable option `opt`.
+func (t *telnet) enableRemote(opt byte) {
+	t.lock.Lock()
+	cmd := t.options[opt].him.requestEnable(telnetDO)
+	t.lock.Unlock()
+	if cmd != 0 {
+		t.sendCommand(cmd, opt)
+	}
+}
+
+// disableRemote asks the remote end to disable option `opt`.
+func (t *telnet) disableRemote(opt byte) {
+	t.lock.Lock()
+	cmd := t.options[opt].him.requestDisable(telnetDONT)
+	t.lock.Unlock()
+	if cmd != 0 {
+		t.sendCommand(cmd, opt)
+	}
+}
+
+// sendCommand writes a telnet command sequence (preceded by IAC)
+// to the raw output stream.
+func (t *telnet) sendCommand(cmd ...byte) error {
+	return t.writeRaw(append([]byte{telnetIAC}, cmd...))
+}
+
+// sendSub writes a subnegotiation for option `opt` containing
+// `data` to the raw output stream.
+func (t *telnet) sendSub(opt byte, data []byte) error {
+	b := []byte{telnetIAC, telnetSB, opt}
+	b = appendEscaped(b, data)
+	b = append(b, telnetIAC, telnetSE)
+	return t.writeRaw(b)
+}
+
+// writeRaw writes unescaped protocol data to the output stream.
+// Compressed data is flushed immediately, so the client never waits
+// on a partial prompt.
+func (t *telnet) writeRaw(b []byte) error {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+	t.rawOut += int64(len(b))
+	if t.z == nil {
+		_, err := telnetWire{t}.Write(b)
+		return err
+	}
+	if _, err := t.z.Write(b); err != nil {
+		return err
+	}
+	return t.z.Flush()
+}
+
+// Write sends data to the telnet stream, escaping any IAC bytes
+// and converting bare line feeds to CR LF.
+func (t *telnet) Write(b []byte) (int, error) {
+	out := make([]byte, 0, len(b)+16)
+	for _, c := range b {
+		switch {
+		case c == telnetIAC:
+			out = append(out, telnetIAC, telnetIAC)
+		case c == '\n' && !t.lastCR:
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, c)
+		}
+		t.lastCR = c == '\r'
+	}
+	if err := t.writeRaw(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// appendEscaped appends `data` to `b`, doubling any IAC bytes.
+func appendEscaped(b, data []byte) []byte {
+	for _, c := range data {
+		if c == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+		b = append(b, c)
+	}
+	return b
+}
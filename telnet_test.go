@@ -0,0 +1,253 @@
+package unimud
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// A telnetSub records a subnegotiation received by the parser.
+type telnetSub struct {
+	opt  byte
+	data string
+}
+
+func TestTelnetRead(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+		subs []telnetSub
+	}{
+		{"plain", "look\r\n", "look\r\n", nil},
+		{"cr nul", "look\r\x00", "look\r\n", nil},
+		{"cr cr nul", "a\r\r\x00b", "a\r\r\nb", nil},
+		{"escaped iac", "a\xff\xffb", "a\xffb", nil},
+		{"nop", "a\xff\xf1b", "ab", nil},
+		{"ayt after cr", "a\r\xff\xf6b", "a\rb", nil},
+		{"negotiation", "\xff\xfb\x18look", "look", nil},
+		{"subnegotiation", "a\xff\xfa\x18\x00xterm\xff\xf0b", "ab",
+			[]telnetSub{{optTTYPE, "\x00xterm"}}},
+		{"escaped iac in subnegotiation", "\xff\xfa\xc9x\xff\xffy\xff\xf0", "",
+			[]telnetSub{{optGMCP, "x\xffy"}}},
+		{"abandoned subnegotiation", "\xff\xfa\xc9x\xff\xf1a", "a", nil},
+		{"two subnegotiations", "\xff\xfa\x18a\xff\xf0\xff\xfa\x1fb\xff\xf0", "",
+			[]telnetSub{{optTTYPE, "a"}, {optNAWS, "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tn := newTelnet(bytes.NewReader([]byte(tt.in)), &out)
+			var subs []telnetSub
+			tn.onSub = func(opt byte, data []byte) {
+				subs = append(subs, telnetSub{opt, string(data)})
+			}
+
+			got, err := io.ReadAll(tn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.out {
+				t.Errorf("read %q, want %q", got, tt.out)
+			}
+			if len(subs) != len(tt.subs) {
+				t.Fatalf("subnegotiations %q, want %q", subs, tt.subs)
+			}
+			for i := range subs {
+				if subs[i] != tt.subs[i] {
+					t.Errorf("subnegotiation %d is %q, want %q", i, subs[i], tt.subs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTelnetSubnegotiationLimit(t *testing.T) {
+	in := []byte{telnetIAC, telnetSB, optGMCP}
+	in = append(in, bytes.Repeat([]byte("x"), maxSubnegotiation+100)...)
+	in = append(in, telnetIAC, telnetSE)
+
+	tn := newTelnet(bytes.NewReader(in), io.Discard)
+	var size int
+	tn.onSub = func(opt byte, data []byte) { size = len(data) }
+	io.ReadAll(tn)
+	if size != maxSubnegotiation {
+		t.Errorf("subnegotiation of %d bytes, want it truncated to %d", size, maxSubnegotiation)
+	}
+}
+
+func TestTelnetNegotiate(t *testing.T) {
+	type change struct {
+		opt            byte
+		local, enabled bool
+	}
+	tests := []struct {
+		name          string
+		local, remote bool   // options the client may enable
+		in            []byte // commands received
+		reply         []byte // commands sent in reply
+		changes       []change
+	}{
+		{"do allowed", true, false,
+			[]byte{telnetIAC, telnetDO, optEcho},
+			[]byte{telnetIAC, telnetWILL, optEcho},
+			[]change{{optEcho, true, true}}},
+		{"do refused", false, false,
+			[]byte{telnetIAC, telnetDO, optEcho},
+			[]byte{telnetIAC, telnetWONT, optEcho},
+			nil},
+		{"will allowed", false, true,
+			[]byte{telnetIAC, telnetWILL, optEcho},
+			[]byte{telnetIAC, telnetDO, optEcho},
+			[]change{{optEcho, false, true}}},
+		{"will refused", false, false,
+			[]byte{telnetIAC, telnetWILL, optEcho},
+			[]byte{telnetIAC, telnetDONT, optEcho},
+			nil},
+		{"dont while disabled", true, true,
+			[]byte{telnetIAC, telnetDONT, optEcho},
+			nil,
+			nil},
+		{"do then dont", true, false,
+			[]byte{telnetIAC, telnetDO, optEcho, telnetIAC, telnetDONT, optEcho},
+			[]byte{telnetIAC, telnetWILL, optEcho, telnetIAC, telnetWONT, optEcho},
+			[]change{{optEcho, true, true}, {optEcho, true, false}}},
+		{"repeated will", false, true,
+			[]byte{telnetIAC, telnetWILL, optEcho, telnetIAC, telnetWILL, optEcho},
+			[]byte{telnetIAC, telnetDO, optEcho},
+			[]change{{optEcho, false, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tn := newTelnet(bytes.NewReader(tt.in), &out)
+			tn.allow(optEcho, tt.local, tt.remote)
+			var changes []change
+			tn.onChange = func(opt byte, local, enabled bool) {
+				changes = append(changes, change{opt, local, enabled})
+			}
+
+			io.ReadAll(tn)
+			if !bytes.Equal(out.Bytes(), tt.reply) {
+				t.Errorf("replied %v, want %v", out.Bytes(), tt.reply)
+			}
+			if len(changes) != len(tt.changes) {
+				t.Fatalf("changes %v, want %v", changes, tt.changes)
+			}
+			for i := range changes {
+				if changes[i] != tt.changes[i] {
+					t.Errorf("change %d is %v, want %v", i, changes[i], tt.changes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQMethod(t *testing.T) {
+	const yes, no = telnetWILL, telnetWONT
+	tests := []struct {
+		name     string
+		start    qSide
+		event    func(q *qSide) byte
+		want     qSide
+		wantSend byte
+	}{
+		// Requests from the other end.
+		{"enable while no", qSide{qNo, false, true}, receiveEnable, qSide{qYes, false, true}, yes},
+		{"enable while no, refused", qSide{qNo, false, false}, receiveEnable, qSide{qNo, false, false}, no},
+		{"enable while yes", qSide{qYes, false, true}, receiveEnable, qSide{qYes, false, true}, 0},
+		{"enable while want no", qSide{qWantNo, false, true}, receiveEnable, qSide{qNo, false, true}, 0},
+		{"enable while want no, opposite", qSide{qWantNo, true, true}, receiveEnable, qSide{qYes, false, true}, 0},
+		{"enable while want yes", qSide{qWantYes, false, true}, receiveEnable, qSide{qYes, false, true}, 0},
+		{"enable while want yes, opposite", qSide{qWantYes, true, true}, receiveEnable, qSide{qWantNo, false, true}, no},
+		{"disable while no", qSide{qNo, false, true}, receiveDisable, qSide{qNo, false, true}, 0},
+		{"disable while yes", qSide{qYes, false, true}, receiveDisable, qSide{qNo, false, true}, no},
+		{"disable while want no", qSide{qWantNo, false, true}, receiveDisable, qSide{qNo, false, true}, 0},
+		{"disable while want no, opposite", qSide{qWantNo, true, true}, receiveDisable, qSide{qWantYes, false, true}, yes},
+		{"disable while want yes", qSide{qWantYes, false, true}, receiveDisable, qSide{qNo, false, true}, 0},
+		{"disable while want yes, opposite", qSide{qWantYes, true, true}, receiveDisable, qSide{qNo, false, true}, 0},
+
+		// Requests from our end.
+		{"request enable while no", qSide{qNo, false, true}, requestEnable, qSide{qWantYes, false, true}, yes},
+		{"request enable while yes", qSide{qYes, false, true}, requestEnable, qSide{qYes, false, true}, 0},
+		{"request enable while want no", qSide{qWantNo, false, true}, requestEnable, qSide{qWantNo, true, true}, 0},
+		{"request enable while want yes, opposite", qSide{qWantYes, true, true}, requestEnable, qSide{qWantYes, false, true}, 0},
+		{"request disable while yes", qSide{qYes, false, true}, requestDisable, qSide{qWantNo, false, true}, no},
+		{"request disable while no", qSide{qNo, false, true}, requestDisable, qSide{qNo, false, true}, 0},
+		{"request disable while want no, opposite", qSide{qWantNo, true, true}, requestDisable, qSide{qWantNo, false, true}, 0},
+		{"request disable while want yes", qSide{qWantYes, false, true}, requestDisable, qSide{qWantYes, true, true}, 0},
+	}
+	for _, tt := range tests {
+		q := tt.start
+		send := tt.event(&q)
+		if q != tt.want || send != tt.wantSend {
+			t.Errorf("%s: state %+v, sent %d, want state %+v, sent %d",
+				tt.name, q, send, tt.want, tt.wantSend)
+		}
+	}
+}
+
+// Q method events for TestQMethod, using WILL and WONT as the
+// commands to send.
+func receiveEnable(q *qSide) byte  { return q.receiveEnable(telnetWILL, telnetWONT) }
+func receiveDisable(q *qSide) byte { return q.receiveDisable(telnetWILL, telnetWONT) }
+func requestEnable(q *qSide) byte  { return q.requestEnable(telnetWILL) }
+func requestDisable(q *qSide) byte { return q.requestDisable(telnetWONT) }
+
+func TestTelnetRequest(t *testing.T) {
+	var out bytes.Buffer
+	tn := newTelnet(bytes.NewReader([]byte{telnetIAC, telnetDO, optGMCP}), &out)
+	tn.enableLocal(optGMCP)
+	tn.enableLocal(optGMCP) // already pending, so nothing is sent
+	if want := []byte{telnetIAC, telnetWILL, optGMCP}; !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("sent %v, want %v", out.Bytes(), want)
+	}
+
+	// The client's agreement completes the negotiation without a
+	// reply, even though the option isn't allowed to be enabled by
+	// the client.
+	out.Reset()
+	io.ReadAll(tn)
+	if out.Len() != 0 {
+		t.Errorf("replied %v to an acknowledgement", out.Bytes())
+	}
+	if !tn.localEnabled(optGMCP) {
+		t.Error("option not enabled after the client agreed")
+	}
+}
+
+func TestTelnetWrite(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"hello\n"}, "hello\r\n"},
+		{[]string{"a\r\nb"}, "a\r\nb"},
+		{[]string{"a\r", "\nb"}, "a\r\nb"},
+		{[]string{"a\n\nb"}, "a\r\n\r\nb"},
+		{[]string{"\xff"}, "\xff\xff"},
+		{[]string{"caf\xc3\xa9\n"}, "caf\xc3\xa9\r\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		tn := newTelnet(nil, &out)
+		for _, s := range tt.in {
+			if n, err := tn.Write([]byte(s)); err != nil || n != len(s) {
+				t.Errorf("Write(%q) = %d, %v", s, n, err)
+			}
+		}
+		if out.String() != tt.want {
+			t.Errorf("writing %q sent %q, want %q", tt.in, out.String(), tt.want)
+		}
+	}
+}
+
+func TestTelnetSendSub(t *testing.T) {
+	var out bytes.Buffer
+	tn := newTelnet(nil, &out)
+	tn.sendSub(optGMCP, []byte("a\xffb"))
+	want := []byte{telnetIAC, telnetSB, optGMCP, 'a', telnetIAC, telnetIAC, 'b', telnetIAC, telnetSE}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("sent %v, want %v", out.Bytes(), want)
+	}
+}
@@ -0,0 +1,66 @@
+package unimud
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSListener(t *testing.T) {
+	g, _ := newTestGame(t, nil)
+	if err := g.Listen(ListenerConfig{Addr: "127.0.0.1:0", TLS: true, SelfSigned: true}); err != nil {
+		t.Fatal(err)
+	}
+	g.listenersLock.Lock()
+	addr := g.listeners[len(g.listeners)-1].Addr().String()
+	g.listenersLock.Unlock()
+
+	// A client that connects and never starts its handshake
+	// doesn't hold up anyone else.
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	nc, err := tls.DialWithDialer(&net.Dialer{Timeout: testTimeout}, "tcp", addr,
+		&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(testTimeout))
+
+	c := &testClient{t, nc, bufio.NewReader(nc)}
+	c.expect("login: ")
+	c.send("alice")
+	c.expect("enter password: ")
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	tests := []struct {
+		addr string
+		host string
+	}{
+		{":2000", "localhost"},
+		{"127.0.0.1:2000", "127.0.0.1"},
+		{"10.1.2.3:2000", "10.1.2.3"},
+		{"mud.example.com:2000", "mud.example.com"},
+	}
+	for _, tt := range tests {
+		cert, err := selfSignedCert(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := leaf.VerifyHostname(tt.host); err != nil {
+			t.Errorf("certificate for %s: %v", tt.addr, err)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package unimud
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListenWebSocket begins listening for HTTP connections on the
+// address described by `lc`. Browsers visiting the address are
+// served a simple terminal page, which connects back to the game
+// using a WebSocket. Connections are accepted on a new goroutine,
+// so ListenWebSocket returns as soon as the listener is bound.
+func (g *Game) ListenWebSocket(lc ListenerConfig) error {
+	var tlsConfig *tls.Config
+	if lc.TLS {
+		var err error
+		if tlsConfig, err = lc.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
+	l, err := g.listenTCP(lc.Addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	fmt.Println("Listening on", lc.Addr, "(WebSocket)")
+
+	// Track the listener.
+	g.listenerAdd(l)
+
+	go func() {
+		http.Serve(l, g.WebSocketHandler())
+		log.Printf("Listen on %s ended.", lc.Addr)
+	}()
+	return nil
+}
+
+// WebSocketHandler returns an HTTP handler that serves the browser
+// client at "/" and accepts WebSocket player connections at "/ws".
+func (g *Game) WebSocketHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, webClientHTML)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := wsUpgrade(w, r)
+		switch {
+		case err == errWSOrigin:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Read the player's input on the handler's goroutine.
+		armReadTimeout(ws.nc)
+		g.serveConn(newConnWebSocket(ws), "")
+	})
+	return mux
+}
+
+// wsGUID is appended to the client's key to compute the handshake
+// response (RFC 6455 section 1.3).
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The largest message accepted from a client.
+const wsMaxMessage = 64 * 1024
+
+// WebSocket frame opcodes.
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xa
+)
+
+var (
+	errWSProtocol = errors.New("websocket: protocol error")
+	errWSOrigin   = errors.New("websocket: origin not allowed")
+)
+
+// A wsConn is a server-side WebSocket connection. Reading from it
+// returns the contents of the client's messages, each terminated
+// by a line feed. Writing to it sends binary messages.
+type wsConn struct {
+	nc         net.Conn
+	r          *bufio.Reader
+	wlock      sync.Mutex // serializes frame writes
+	pending    []byte     // message data not yet returned by Read
+	fragmented bool       // true while the frames of a fragmented message are arriving
+	closed     bool       // true once a close frame has been sent
+}
+
+// wsUpgrade performs the server side of the WebSocket opening
+// handshake and takes over the HTTP connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("websocket: missing key")
+	}
+
+	// Browsers send the origin of the page that opened the
+	// WebSocket. Only pages served by this listener may connect,
+	// so other web sites can't connect on a visitor's behalf.
+	// Clients that aren't browsers send no origin.
+	if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+		return nil, errWSOrigin
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websocket: connection can't be hijacked")
+	}
+	nc, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(h[:])
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := nc.Write([]byte(resp)); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &wsConn{nc: nc, r: rw.Reader}, nil
+}
+
+// sameOrigin returns true if the URL `origin` refers to the host
+// `host`, which includes the port if it isn't the default.
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, host)
+}
+
+// headerContains returns true if the comma-separated header `name`
+// contains the token `value`, ignoring case.
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, tok := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(tok), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read returns data from the client's messages. Control frames are
+// handled as they arrive.
+func (ws *wsConn) Read(b []byte) (int, error) {
+	for len(ws.pending) == 0 {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			// A continuation frame must continue a fragmented
+			// message, and a new message can't begin until a
+			// fragmented one has ended.
+			if (op == wsOpContinuation) != ws.fragmented {
+				ws.writeClose(1002)
+				return 0, errWSProtocol
+			}
+			ws.fragmented = !fin
+			ws.pending = payload
+			if fin {
+				ws.pending = append(ws.pending, '\n')
+			}
+		case wsOpPing:
+			ws.writeFrame(wsOpPong, payload)
+		case wsOpPong:
+			// Ignore.
+		case wsOpClose:
+			ws.writeClose(1000)
+			return 0, io.EOF
+		default:
+			ws.writeClose(1002)
+			return 0, errWSProtocol
+		}
+	}
+
+	n := copy(b, ws.pending)
+	ws.pending = ws.pending[n:]
+	return n, nil
+}
+
+// readFrame reads a single frame from the client and unmasks its
+// payload.
+func (ws *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(ws.r, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0f
+
+	// Clients must mask every frame.
+	if hdr[1]&0x80 == 0 {
+		ws.writeClose(1002)
+		return fin, op, nil, errWSProtocol
+	}
+
+	size := uint64(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	// Control frames can't be fragmented, and carry at most 125
+	// bytes.
+	if op&0x8 != 0 && (!fin || size > 125) {
+		ws.writeClose(1002)
+		return fin, op, nil, errWSProtocol
+	}
+	if size > wsMaxMessage {
+		ws.writeClose(1009)
+		return fin, op, nil, errors.New("websocket: message too large")
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(ws.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// Write sends `b` to the client as a binary message.
+func (ws *wsConn) Write(b []byte) (int, error) {
+	if err := ws.writeFrame(wsOpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeText sends `s` to the client as a text message. The browser
+// client treats text messages as control messages.
+func (ws *wsConn) writeText(s string) error {
+	return ws.writeFrame(wsOpText, []byte(s))
+}
+
+// writeFrame sends a single unmasked, unfragmented frame.
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.wlock.Lock()
+	defer ws.wlock.Unlock()
+	if ws.closed {
+		return io.ErrClosedPipe
+	}
+	if op == wsOpClose {
+		ws.closed = true
+	}
+
+	hdr := make([]byte, 0, 10+len(payload))
+	hdr = append(hdr, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		hdr = append(hdr, 127)
+		hdr = append(hdr, ext[:]...)
+	}
+	_, err := ws.nc.Write(append(hdr, payload...))
+	return err
+}
+
+// writeClose sends a close frame with a status code.
+func (ws *wsConn) writeClose(code uint16) error {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], code)
+	return ws.writeFrame(wsOpClose, b[:])
+}
+
+// Close sends a close frame and closes the underlying connection.
+// A client that won't accept the close frame promptly is cut off.
+func (ws *wsConn) Close() error {
+	ws.nc.SetWriteDeadline(time.Now().Add(time.Second))
+	ws.writeClose(1000)
+	return ws.nc.Close()
+}
+
+// webClientHTML is the browser client served by the WebSocket
+// listener.
+const webClientHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>UniMUD</title>
+<style>
+body { margin: 0; background: #000; color: #ccc; font: 14px monospace; }
+#out { margin: 0; padding: 8px; height: calc(100vh - 48px); overflow-y: auto; white-space: pre-wrap; }
+#f { padding: 0 8px; }
+#in { width: 100%; box-sizing: border-box; background: #111; color: #eee; border: 1px solid #444; font: inherit; padding: 4px; }
+</style>
+</head>
+<body>
+<pre id="out"></pre>
+<form id="f"><input id="in" type="text" autocomplete="off" autofocus></form>
+<script>
+(function() {
+	var out = document.getElementById("out");
+	var form = document.getElementById("f");
+	var input = document.getElementById("in");
+	var decoder = new TextDecoder();
+
+	// The 16 ANSI colors and the current foreground color.
+	var palette = ["#000", "#c00", "#0c0", "#cc0", "#00e", "#c0c", "#0cc", "#e5e5e5",
+		"#7f7f7f", "#f00", "#0f0", "#ff0", "#5c5cff", "#f0f", "#0ff", "#fff"];
+	var color = "";
+	var partial = "";
+
+	function xterm(n) {
+		if (n < 16) {
+			return palette[n];
+		}
+		if (n >= 232) {
+			var v = 8 + (n - 232) * 10;
+			return "rgb(" + v + "," + v + "," + v + ")";
+		}
+		n -= 16;
+		var level = function(i) { return i ? 55 + i * 40 : 0; };
+		return "rgb(" + level(Math.floor(n / 36)) + "," + level(Math.floor(n / 6) % 6) + "," + level(n % 6) + ")";
+	}
+
+	// sgr updates the current color from the parameters of an SGR
+	// escape sequence.
+	function sgr(params) {
+		var p = params.split(";").map(Number);
+		for (var i = 0; i < p.length; i++) {
+			var n = p[i];
+			if (n === 0 || n === 39) {
+				color = "";
+			} else if (n >= 30 && n <= 37) {
+				color = palette[n - 30];
+			} else if (n >= 90 && n <= 97) {
+				color = palette[n - 90 + 8];
+			} else if (n === 38 && p[i+1] === 5) {
+				color = xterm(p[i+2]);
+				i += 2;
+			} else if (n === 38 && p[i+1] === 2) {
+				color = "rgb(" + p[i+2] + "," + p[i+3] + "," + p[i+4] + ")";
+				i += 4;
+			}
+		}
+	}
+
+	function text(s) {
+		if (s === "") {
+			return;
+		}
+		var node = document.createTextNode(s);
+		if (color) {
+			var span = document.createElement("span");
+			span.style.color = color;
+			span.appendChild(node);
+			node = span;
+		}
+		out.appendChild(node);
+	}
+
+	function write(s) {
+		s = partial + s.replace(/\r/g, "");
+		partial = "";
+		var re = /\x1b\[([0-9;]*)m/g, last = 0, m;
+		while ((m = re.exec(s)) !== null) {
+			text(s.slice(last, m.index));
+			sgr(m[1]);
+			last = re.lastIndex;
+		}
+		s = s.slice(last);
+
+		// Hold back an escape sequence split across messages.
+		var esc = s.lastIndexOf("\x1b");
+		if (esc >= 0 && /^\x1b(\[[0-9;]*)?$/.test(s.slice(esc))) {
+			partial = s.slice(esc);
+			s = s.slice(0, esc);
+		}
+		text(s);
+		out.scrollTop = out.scrollHeight;
+	}
+
+	var proto = location.protocol === "https:" ? "wss:" : "ws:";
+	var ws = new WebSocket(proto + "//" + location.host + "/ws");
+	ws.binaryType = "arraybuffer";
+
+	ws.onmessage = function(e) {
+		if (typeof e.data === "string") {
+			// Text messages control the client.
+			var msg = JSON.parse(e.data);
+			if ("echo" in msg) {
+				input.type = msg.echo ? "text" : "password";
+			}
+			return;
+		}
+		write(decoder.decode(new Uint8Array(e.data), {stream: true}));
+	};
+	ws.onclose = function() {
+		write("\n[connection closed]\n");
+		input.disabled = true;
+	};
+
+	form.onsubmit = function(e) {
+		e.preventDefault();
+		var line = input.value;
+		input.value = "";
+		if (input.type === "text") {
+			write(line + "\n");
+		}
+		ws.send(line);
+	};
+})();
+</script>
+</body>
+</html>
+`
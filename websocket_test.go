@@ -0,0 +1,278 @@
+package unimud
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestKey is the sample handshake key from RFC 6455 section 1.3.
+const wsTestKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// wsTestClient is the client end of a WebSocket connection to a
+// test server.
+type wsTestClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// wsTestDial sends an opening handshake with the headers `hdr` to
+// the test server and returns the connection and the response.
+func wsTestDial(t *testing.T, srv *httptest.Server, hdr map[string]string) (*wsTestClient, *http.Response) {
+	t.Helper()
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest("GET", srv.URL+"/ws", nil)
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	if err := req.Write(nc); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsTestClient{t, nc, r}, resp
+}
+
+// wsTestHeaders returns the headers of a valid opening handshake.
+func wsTestHeaders() map[string]string {
+	return map[string]string{
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     wsTestKey,
+	}
+}
+
+// send writes a masked frame, as a client must.
+func (c *wsTestClient) send(fin bool, op byte, payload []byte) {
+	b := []byte{op, 0x80}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b[1] |= byte(n)
+	default:
+		b[1] |= 126
+		b = append(b, byte(n>>8), byte(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask...)
+	for i, x := range payload {
+		b = append(b, x^mask[i%4])
+	}
+	if _, err := c.nc.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// receive reads an unfragmented frame sent by the server.
+func (c *wsTestClient) receive() (op byte, payload []byte) {
+	c.t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if hdr[0]&0x80 == 0 || hdr[1]&0x80 != 0 {
+		c.t.Fatalf("frame header %x: want final and unmasked", hdr)
+	}
+	size := int(hdr[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+// wsEchoHandler upgrades the connection and sends each line the
+// client sends back as a binary message.
+func wsEchoHandler(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrade(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer ws.Close()
+
+	sc := bufio.NewScanner(ws)
+	for sc.Scan() {
+		ws.Write([]byte(sc.Text()))
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	cfg := DefaultConfig()
+	g := NewGame(cfg)
+	g.PlayerStore = NewMemPlayerStore()
+	go g.Run()
+	defer func() {
+		g.Shutdown()
+		<-g.DoneChan
+	}()
+
+	srv := httptest.NewServer(g.WebSocketHandler())
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	tests := []struct {
+		name   string
+		hdr    map[string]string
+		status int
+	}{
+		{"valid", nil, http.StatusSwitchingProtocols},
+		{"same origin", map[string]string{"Origin": "http://" + host}, http.StatusSwitchingProtocols},
+		{"other origin", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"bad origin", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"no key", map[string]string{"Sec-WebSocket-Key": ""}, http.StatusBadRequest},
+		{"old version", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusBadRequest},
+		{"no upgrade", map[string]string{"Upgrade": ""}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := wsTestHeaders()
+			for k, v := range tt.hdr {
+				hdr[k] = v
+			}
+			c, resp := wsTestDial(t, srv, hdr)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusSwitchingProtocols {
+				return
+			}
+
+			h := sha1.Sum([]byte(wsTestKey + wsGUID))
+			accept := base64.StdEncoding.EncodeToString(h[:])
+			if got := resp.Header.Get("Sec-WebSocket-Accept"); got != accept {
+				t.Errorf("Sec-WebSocket-Accept %q, want %q", got, accept)
+			}
+
+			// The game greets the new player with a login prompt.
+			var text string
+			for !strings.Contains(text, "login: ") {
+				op, payload := c.receive()
+				if op == wsOpBinary {
+					text += string(payload)
+				}
+			}
+		})
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(wsEchoHandler))
+	defer srv.Close()
+
+	c, resp := wsTestDial(t, srv, wsTestHeaders())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	// A single-frame message.
+	c.send(true, wsOpText, []byte("hello"))
+	if op, payload := c.receive(); op != wsOpBinary || string(payload) != "hello" {
+		t.Errorf("got op %d %q, want binary %q", op, payload, "hello")
+	}
+
+	// A fragmented message interrupted by a ping.
+	c.send(false, wsOpText, []byte("frag"))
+	c.send(true, wsOpPing, []byte("ping"))
+	c.send(false, wsOpContinuation, []byte("men"))
+	c.send(true, wsOpContinuation, []byte("ted"))
+	if op, payload := c.receive(); op != wsOpPong || string(payload) != "ping" {
+		t.Errorf("got op %d %q, want pong %q", op, payload, "ping")
+	}
+	if op, payload := c.receive(); op != wsOpBinary || string(payload) != "fragmented" {
+		t.Errorf("got op %d %q, want binary %q", op, payload, "fragmented")
+	}
+
+	// A message large enough to need an extended length.
+	long := strings.Repeat("x", 300)
+	c.send(true, wsOpBinary, []byte(long))
+	if _, payload := c.receive(); string(payload) != long {
+		t.Errorf("got %d bytes, want %d", len(payload), len(long))
+	}
+
+	// The server answers a close frame with its own.
+	c.send(true, wsOpClose, []byte{0x03, 0xe8})
+	if op, payload := c.receive(); op != wsOpClose || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Errorf("got op %d %x, want close 1000", op, payload)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(wsEchoHandler))
+	defer srv.Close()
+
+	type frame struct {
+		fin     bool
+		op      byte
+		payload string
+	}
+	tests := []struct {
+		name   string
+		frames []frame
+	}{
+		{"long ping", []frame{{true, wsOpPing, strings.Repeat("p", 126)}}},
+		{"long close", []frame{{true, wsOpClose, strings.Repeat("c", 126)}}},
+		{"fragmented ping", []frame{{false, wsOpPing, "p"}}},
+		{"lone continuation", []frame{{true, wsOpContinuation, "x"}}},
+		{"interrupted message", []frame{{false, wsOpText, "a"}, {true, wsOpText, "b"}}},
+		{"unknown opcode", []frame{{true, 0x3, "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := wsTestDial(t, srv, wsTestHeaders())
+			for _, f := range tt.frames {
+				c.send(f.fin, f.op, []byte(f.payload))
+			}
+			op, payload := c.receive()
+			if op != wsOpClose || len(payload) != 2 || binary.BigEndian.Uint16(payload) != 1002 {
+				t.Errorf("got op %d %x, want close 1002", op, payload)
+			}
+		})
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin, host string
+		want         bool
+	}{
+		{"http://example.com", "example.com", true},
+		{"https://Example.com:8080", "example.com:8080", true},
+		{"http://example.com:8080", "example.com", false},
+		{"http://other.com", "example.com", false},
+		{"null", "example.com", false},
+		{"", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := sameOrigin(tt.origin, tt.host); got != tt.want {
+			t.Errorf("sameOrigin(%q, %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+		}
+	}
+}
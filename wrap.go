@@ -0,0 +1,85 @@
+package unimud
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Output narrower than this isn't wrapped any further, since
+// wrapping would make it unreadable.
+const minWrapWidth = 20
+
+// The screen size assumed for clients that don't report their size.
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// wrapMarkup word-wraps the text `s`, which may contain color
+// markup, to lines of at most `width` visible characters. The
+// output is assumed to begin at column `col`. It returns the
+// wrapped text and the column at which it ends. Words longer than
+// a line are left intact.
+func wrapMarkup(s string, width, col int) (string, int) {
+	if width <= 0 {
+		return s, col
+	}
+	if width < minWrapWidth {
+		width = minWrapWidth
+	}
+
+	var b strings.Builder
+	spaces := 0
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\n':
+			b.WriteByte('\n')
+			col, spaces = 0, 0
+			i++
+		case ' ':
+			spaces++
+			i++
+		default:
+			end := i
+			for end < len(s) && s[end] != ' ' && s[end] != '\n' {
+				end++
+			}
+			word := s[i:end]
+			n := markupLen(word)
+			if col > 0 && col+spaces+n > width {
+				b.WriteByte('\n')
+				col, spaces = 0, 0
+			}
+			b.WriteString(strings.Repeat(" ", spaces))
+			b.WriteString(word)
+			col += spaces + n
+			spaces = 0
+			i = end
+		}
+	}
+
+	// Keep trailing spaces, as in prompts.
+	b.WriteString(strings.Repeat(" ", spaces))
+	return b.String(), col + spaces
+}
+
+// markupLen returns the number of visible characters in `s`, not
+// counting color markup.
+func markupLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		tag, tn := markupTag(s[i:])
+		switch {
+		case tn == 0:
+			_, size := utf8.DecodeRuneInString(s[i:])
+			n++
+			i += size
+		case tag == "{":
+			n++
+			i += tn
+		default:
+			i += tn
+		}
+	}
+	return n
+}
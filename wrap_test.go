@@ -0,0 +1,58 @@
+package unimud
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapMarkup(t *testing.T) {
+	long := strings.Repeat("x", 25)
+	tests := []struct {
+		s          string
+		width, col int
+		want       string
+		wantCol    int
+	}{
+		{"hello world", 0, 5, "hello world", 5},
+		{"the quick brown fox jumps over the lazy dog", 20, 0,
+			"the quick brown fox\njumps over the lazy\ndog", 3},
+		{"aaaaa bbbbb ccccc ddddd", 10, 0, "aaaaa bbbbb ccccc\nddddd", 5},
+		{"a " + long, 20, 0, "a\n" + long, 25},
+		{long, 20, 0, long, 25},
+		{"{r}red{x} text", 20, 15, "{r}red{x}\ntext", 4},
+		{"word", 20, 18, "\nword", 4},
+		{"abc   def", 20, 15, "abc\ndef", 3},
+		{"one\ntwo", 20, 0, "one\ntwo", 3},
+		{"login: ", 20, 0, "login: ", 7},
+		{"", 20, 7, "", 7},
+	}
+	for _, tt := range tests {
+		got, col := wrapMarkup(tt.s, tt.width, tt.col)
+		if got != tt.want || col != tt.wantCol {
+			t.Errorf("wrapMarkup(%q, %d, %d) = %q, %d, want %q, %d",
+				tt.s, tt.width, tt.col, got, col, tt.want, tt.wantCol)
+		}
+	}
+}
+
+func TestMarkupLen(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"plain", 5},
+		{"{r}red{x}", 3},
+		{"{{", 1},
+		{"{{r}", 3},
+		{"{#ff0000}x", 1},
+		{"{#zz}", 5},
+		{"{q}", 3},
+		{"héllo", 5},
+		{"{", 1},
+	}
+	for _, tt := range tests {
+		if got := markupLen(tt.s); got != tt.want {
+			t.Errorf("markupLen(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}